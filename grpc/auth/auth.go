@@ -0,0 +1,212 @@
+// Package auth 提供 gRPC 服务端的认证授权拦截器：
+// 从 metadata 中读取 JWT（authorization: Bearer <token>）或 API key（x-api-key），
+// 再按方法配置的允许列表授权
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// 认证使用的 metadata key
+const (
+	AuthorizationKey = "authorization"
+	APIKeyKey        = "x-api-key"
+)
+
+// 健康检查服务默认不需要认证，客户端负载均衡器的健康检查不带凭据
+const healthMethods = "/grpc.health.v1.Health/*"
+
+// 认证授权配置
+type Config struct {
+	JWT JWTConfig `yaml:"jwt"`
+	// API key 到调用方名称的映射
+	APIKeys map[string]string `yaml:"apiKeys"`
+	// 按方法配置的访问规则，key 为完整方法名（/hello.HelloService/SayHello）、
+	// 服务下的全部方法（/hello.HelloService/*）或全部方法（*）；
+	// 没有匹配的规则时要求通过认证，不限制调用方
+	Methods map[string]MethodRule `yaml:"methods"`
+	// 进程内部调用（如健康检查的回环请求）使用的 API key，通过所有方法的授权，不从配置文件读取
+	InternalKey string `yaml:"-"`
+}
+
+// 单个方法的访问规则
+type MethodRule struct {
+	// 不需要认证
+	Public bool `yaml:"public"`
+	// 允许的调用方（JWT 的 sub 或 API key 对应的名称），为空表示任意通过认证的调用方
+	Allow []string `yaml:"allow"`
+}
+
+// Enabled 配置了 JWT 或 API key 时启用认证
+func (c *Config) Enabled() bool {
+	return c.JWT.enabled() || len(c.APIKeys) > 0
+}
+
+// 通过认证的调用方
+type Principal struct {
+	// JWT 的 sub 或 API key 对应的名称
+	Name string
+	// 认证方式：jwt、api-key 或 internal
+	Method string
+	// JWT 的全部 claims，API key 认证时为空
+	Claims map[string]any
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext 返回拦截器放入 context 的调用方，公开方法且没有携带凭据时返回 false
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Authenticator 校验请求凭据并按方法授权
+type Authenticator struct {
+	cfg Config
+	jwt *jwtVerifier
+}
+
+// NewAuthenticator 根据配置创建认证器，JWKS 文件在创建时读取，之后遇到未知的 kid 时检查文件是否有更新
+func NewAuthenticator(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{cfg: cfg}
+	if cfg.JWT.enabled() {
+		v, err := newJWTVerifier(cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		a.jwt = v
+	}
+	if a.cfg.Methods == nil {
+		a.cfg.Methods = make(map[string]MethodRule)
+	}
+	if _, ok := a.cfg.Methods[healthMethods]; !ok {
+		a.cfg.Methods[healthMethods] = MethodRule{Public: true}
+	}
+	return a, nil
+}
+
+// 依次匹配完整方法名、服务通配和全局通配
+func (a *Authenticator) rule(method string) (MethodRule, bool) {
+	if r, ok := a.cfg.Methods[method]; ok {
+		return r, true
+	}
+	if i := strings.LastIndex(method, "/"); i > 0 {
+		if r, ok := a.cfg.Methods[method[:i+1]+"*"]; ok {
+			return r, true
+		}
+	}
+	r, ok := a.cfg.Methods["*"]
+	return r, ok
+}
+
+// 从 metadata 中认证调用方，没有携带凭据时返回 nil
+func (a *Authenticator) authenticate(md metadata.MD) (*Principal, error) {
+	if key := firstValue(md, APIKeyKey); key != "" {
+		if a.cfg.InternalKey != "" && equalKeys(key, a.cfg.InternalKey) {
+			return &Principal{Name: "internal", Method: "internal"}, nil
+		}
+		// 逐个按常量时间比较，不用 map 查找，避免通过响应时间猜测 key
+		for k, name := range a.cfg.APIKeys {
+			if equalKeys(key, k) {
+				return &Principal{Name: name, Method: "api-key"}, nil
+			}
+		}
+		return nil, errors.New("invalid API key")
+	}
+
+	if authz := firstValue(md, AuthorizationKey); authz != "" {
+		scheme, token, ok := strings.Cut(authz, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			return nil, errors.New("authorization must use the Bearer scheme")
+		}
+		if a.jwt == nil {
+			return nil, errors.New("JWT authentication is not enabled")
+		}
+		claims, err := a.jwt.verify(strings.TrimSpace(token))
+		if err != nil {
+			return nil, fmt.Errorf("invalid token: %v", err)
+		}
+		sub, _ := claims["sub"].(string)
+		return &Principal{Name: sub, Method: "jwt", Claims: claims}, nil
+	}
+	return nil, nil
+}
+
+// 认证并授权，成功时返回带有调用方的 context
+func (a *Authenticator) check(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	rule, _ := a.rule(method)
+
+	p, err := a.authenticate(md)
+	if err != nil {
+		// 公开方法也拒绝无效的凭据，避免调用方误以为凭据有效
+		return nil, status.Errorf(codes.Unauthenticated, "%s: %v", method, err)
+	}
+	if p == nil {
+		if rule.Public {
+			return ctx, nil
+		}
+		return nil, status.Errorf(codes.Unauthenticated, "%s requires a bearer token or an API key", method)
+	}
+
+	if !rule.Public && len(rule.Allow) > 0 && p.Method != "internal" && !contains(rule.Allow, p.Name) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", p.Name, method)
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+// UnaryServerInterceptor 一元调用的认证授权拦截器
+func (a *Authenticator) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.check(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// StreamServerInterceptor 流式调用的认证授权拦截器
+func (a *Authenticator) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.check(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func equalKeys(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	sayHello   = "/hello.HelloService/SayHello"
+	otherHello = "/hello.HelloService/SayGoodbye"
+	otherSvc   = "/other.Service/Call"
+	healthChk  = "/grpc.health.v1.Health/Check"
+)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+	a, err := NewAuthenticator(Config{
+		JWT:     JWTConfig{HMACSecret: "secret"},
+		APIKeys: map[string]string{"alice-key": "alice", "bob-key": "bob", "carol-key": "carol"},
+		// 完整方法名优先于服务通配，服务通配优先于全局通配
+		Methods: map[string]MethodRule{
+			sayHello:                {Allow: []string{"alice"}},
+			"/hello.HelloService/*": {Allow: []string{"bob"}},
+			"*":                     {Allow: []string{"carol"}},
+			"/public.Service/Open":  {Public: true},
+		},
+		InternalKey: "internal-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func hmacToken(t *testing.T, sub string) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub": sub,
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestCheck(t *testing.T) {
+	a := newTestAuthenticator(t)
+	apiKey := func(key string) metadata.MD { return metadata.Pairs(APIKeyKey, key) }
+	bearer := func(token string) metadata.MD { return metadata.Pairs(AuthorizationKey, "Bearer "+token) }
+
+	tests := []struct {
+		name     string
+		method   string
+		md       metadata.MD
+		wantCode codes.Code
+		wantName string
+	}{
+		{"method rule allows", sayHello, apiKey("alice-key"), codes.OK, "alice"},
+		{"method rule wins over service rule", sayHello, apiKey("bob-key"), codes.PermissionDenied, ""},
+		{"method rule wins over global rule", sayHello, apiKey("carol-key"), codes.PermissionDenied, ""},
+		{"service rule allows", otherHello, apiKey("bob-key"), codes.OK, "bob"},
+		{"service rule wins over global rule", otherHello, apiKey("carol-key"), codes.PermissionDenied, ""},
+		{"service rule denies", otherHello, apiKey("alice-key"), codes.PermissionDenied, ""},
+		{"global rule allows", otherSvc, apiKey("carol-key"), codes.OK, "carol"},
+		{"global rule denies", otherSvc, apiKey("alice-key"), codes.PermissionDenied, ""},
+		{"JWT allowed", sayHello, bearer(hmacToken(t, "alice")), codes.OK, "alice"},
+		{"JWT denied", sayHello, bearer(hmacToken(t, "mallory")), codes.PermissionDenied, ""},
+		{"bearer scheme is case insensitive", sayHello, metadata.Pairs(AuthorizationKey, "bearer "+hmacToken(t, "alice")), codes.OK, "alice"},
+		{"internal key bypasses allow-lists", sayHello, apiKey("internal-key"), codes.OK, "internal"},
+		{"no credentials", sayHello, nil, codes.Unauthenticated, ""},
+		{"bad API key", sayHello, apiKey("wrong-key"), codes.Unauthenticated, ""},
+		{"internal key prefix", sayHello, apiKey("internal"), codes.Unauthenticated, ""},
+		{"invalid token", sayHello, bearer("not-a-token"), codes.Unauthenticated, ""},
+		{"wrong scheme", sayHello, metadata.Pairs(AuthorizationKey, "Basic YWxpY2U6cHc="), codes.Unauthenticated, ""},
+		{"public method without credentials", "/public.Service/Open", nil, codes.OK, ""},
+		{"public method with credentials", "/public.Service/Open", apiKey("alice-key"), codes.OK, "alice"},
+		{"health without credentials", healthChk, nil, codes.OK, ""},
+		// 公开方法也拒绝无效的凭据
+		{"health with bad API key", healthChk, apiKey("wrong-key"), codes.Unauthenticated, ""},
+		{"health with invalid token", healthChk, bearer("not-a-token"), codes.Unauthenticated, ""},
+		{"public method with bad API key", "/public.Service/Open", apiKey("wrong-key"), codes.Unauthenticated, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			ctx, err := a.check(ctx, tt.method)
+			if got := status.Code(err); got != tt.wantCode {
+				t.Fatalf("check() = %v, want %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			p, ok := PrincipalFromContext(ctx)
+			if tt.wantName == "" {
+				if ok {
+					t.Errorf("principal %+v, want none", p)
+				}
+				return
+			}
+			if !ok || p.Name != tt.wantName {
+				t.Errorf("principal = %+v, want %s", p, tt.wantName)
+			}
+		})
+	}
+}
+
+// 没有匹配的规则时要求通过认证，但不限制调用方
+func TestCheckWithoutRules(t *testing.T) {
+	a, err := NewAuthenticator(Config{APIKeys: map[string]string{"alice-key": "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyKey, "alice-key"))
+	if _, err := a.check(ctx, sayHello); err != nil {
+		t.Errorf("authenticated caller rejected: %v", err)
+	}
+	if _, err := a.check(context.Background(), sayHello); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("anonymous caller: %v, want Unauthenticated", err)
+	}
+	// JWT 未启用时 bearer token 无效
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(AuthorizationKey, "Bearer "+hmacToken(t, "alice")))
+	if _, err := a.check(ctx, sayHello); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("bearer token without JWT config: %v, want Unauthenticated", err)
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	a := newTestAuthenticator(t)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyKey, "alice-key"))
+
+	var got string
+	_, err := a.UnaryServerInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: sayHello}, func(ctx context.Context, req any) (any, error) {
+		p, _ := PrincipalFromContext(ctx)
+		got = p.Name
+		return nil, nil
+	})
+	if err != nil || got != "alice" {
+		t.Errorf("unary: principal %q, err %v", got, err)
+	}
+
+	called := false
+	_, err = a.UnaryServerInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: otherSvc}, func(context.Context, any) (any, error) {
+		called = true
+		return nil, nil
+	})
+	if status.Code(err) != codes.PermissionDenied || called {
+		t.Errorf("unary: err %v, handler called %v, want PermissionDenied without calling the handler", err, called)
+	}
+
+	got = ""
+	err = a.StreamServerInterceptor(nil, &fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: sayHello}, func(srv any, ss grpc.ServerStream) error {
+		p, _ := PrincipalFromContext(ss.Context())
+		got = p.Name
+		return nil
+	})
+	if err != nil || got != "alice" {
+		t.Errorf("stream: principal %q, err %v", got, err)
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"context"
+	"flag"
+	"net/textproto"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+)
+
+// CallCredentials 客户端每次调用携带的凭据，通过 grpc.WithPerRPCCredentials 使用
+type CallCredentials struct {
+	BearerToken string
+	APIKey      string
+	// 允许在明文连接上发送凭据，仅用于本地调试
+	AllowInsecure bool
+}
+
+// AddFlags 注册客户端使用的凭据命令行参数
+func (c *CallCredentials) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.BearerToken, "token", c.BearerToken, "JWT sent as a bearer token")
+	fs.StringVar(&c.APIKey, "api-key", c.APIKey, "API key sent in x-api-key")
+}
+
+// DialOptions 配置了凭据时返回携带凭据的 DialOption，否则返回 nil
+func (c CallCredentials) DialOptions() []grpc.DialOption {
+	if c.BearerToken == "" && c.APIKey == "" {
+		return nil
+	}
+	return []grpc.DialOption{grpc.WithPerRPCCredentials(c)}
+}
+
+func (c CallCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	md := make(map[string]string, 2)
+	if c.BearerToken != "" {
+		md[AuthorizationKey] = "Bearer " + c.BearerToken
+	}
+	if c.APIKey != "" {
+		md[APIKeyKey] = c.APIKey
+	}
+	return md, nil
+}
+
+func (c CallCredentials) RequireTransportSecurity() bool {
+	return !c.AllowInsecure
+}
+
+// GatewayHeaderMatcher 把 REST 请求的 X-Api-Key 头转发为 gRPC metadata，其余按网关默认规则处理。
+// Authorization 头总是由网关以 authorization 转发，不需要在这里处理
+func GatewayHeaderMatcher(key string) (string, bool) {
+	if textproto.CanonicalMIMEHeaderKey(key) == textproto.CanonicalMIMEHeaderKey(APIKeyKey) {
+		return APIKeyKey, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCallCredentials(t *testing.T) {
+	if opts := (CallCredentials{}).DialOptions(); opts != nil {
+		t.Errorf("DialOptions() without credentials = %v, want nil", opts)
+	}
+
+	c := CallCredentials{BearerToken: "token", APIKey: "key"}
+	md, err := c.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md[AuthorizationKey] != "Bearer token" || md[APIKeyKey] != "key" {
+		t.Errorf("metadata = %v", md)
+	}
+	if !c.RequireTransportSecurity() {
+		t.Error("credentials sent over plaintext connections by default")
+	}
+	c.AllowInsecure = true
+	if c.RequireTransportSecurity() {
+		t.Error("AllowInsecure ignored")
+	}
+}
+
+func TestGatewayHeaderMatcher(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{"X-Api-Key", APIKeyKey, true},
+		{"x-api-key", APIKeyKey, true},
+		{"Grpc-Metadata-Trace", "Trace", true},
+		{"X-Custom", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := GatewayHeaderMatcher(tt.header)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("GatewayHeaderMatcher(%q) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
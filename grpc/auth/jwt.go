@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWT 校验配置，HMACSecret 和 JWKSFile 至少配置一个
+type JWTConfig struct {
+	// HS256/HS384/HS512 使用的共享密钥
+	HMACSecret string `yaml:"hmacSecret"`
+	// 本地 JWKS 文件，用于 RS*/PS*/ES* 签名的 token，按 kid 选择公钥；
+	// 轮换密钥时直接改写文件，遇到未知的 kid 时会重新读取
+	JWKSFile string `yaml:"jwksFile"`
+	// 非空时校验 iss
+	Issuer string `yaml:"issuer"`
+	// 非空时校验 aud
+	Audience string `yaml:"audience"`
+}
+
+func (c *JWTConfig) enabled() bool {
+	return c.HMACSecret != "" || c.JWKSFile != ""
+}
+
+type jwtVerifier struct {
+	cfg    JWTConfig
+	parser *jwt.Parser
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+	// 当前 keys 对应的 JWKS 文件版本
+	jwksVersion fileVersion
+}
+
+// 文件的修改时间和大小，任一变化就认为文件已更新
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+func newJWTVerifier(cfg JWTConfig) (*jwtVerifier, error) {
+	v := &jwtVerifier{cfg: cfg}
+
+	var methods []string
+	if cfg.HMACSecret != "" {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.JWKSFile != "" {
+		version, err := statFile(cfg.JWKSFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWKS: %v", err)
+		}
+		keys, err := readJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.keys, v.jwksVersion = keys, version
+		methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	v.parser = jwt.NewParser(opts...)
+	return v, nil
+}
+
+func (v *jwtVerifier) verify(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(token, claims, v.key); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// 按签名算法和 kid 选择校验密钥
+func (v *jwtVerifier) key(token *jwt.Token) (any, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		return []byte(v.cfg.HMACSecret), nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	if key, ok := v.lookup(kid); ok {
+		return key, nil
+	}
+	if v.refresh() {
+		if key, ok := v.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// 按 kid 查找公钥，JWKS 中只有一个密钥时 token 可以不带 kid
+func (v *jwtVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// JWKS 文件有更新时重新读取，返回密钥是否发生了变化。
+// 只比较文件的修改时间和大小，携带随机 kid 的请求不会导致反复解析文件；
+// 新文件无效时保留原有密钥，直到文件再次更新
+func (v *jwtVerifier) refresh() bool {
+	version, err := statFile(v.cfg.JWKSFile)
+	if err != nil {
+		return false
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if version == v.jwksVersion {
+		return false
+	}
+	v.jwksVersion = version
+	keys, err := readJWKS(v.cfg.JWKSFile)
+	if err != nil {
+		log.Printf("Failed to reload JWKS, keeping the previous keys: %v", err)
+		return false
+	}
+	v.keys = keys
+	log.Printf("Reloaded %d keys from %s", len(keys), v.cfg.JWKSFile)
+	return true
+}
+
+// JWKS 中的一个公钥，只支持 RSA 和 EC
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func readJWKS(path string) (map[string]crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %v", err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS %s: %v", path, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q in %s: %v", k.Kid, path, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found in %s", path)
+	}
+	return keys, nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid base64url integer")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 测试用的签名密钥，kid 对应 JWKS 中的条目
+type testKey struct {
+	kid    string
+	method jwt.SigningMethod
+	key    any
+}
+
+func newRSAKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	k, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, method: jwt.SigningMethodRS256, key: k}
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid: kid, method: jwt.SigningMethodES256, key: k}
+}
+
+func (k testKey) jwk() map[string]string {
+	enc := func(b *big.Int) string { return base64.RawURLEncoding.EncodeToString(b.Bytes()) }
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		return map[string]string{"kty": "RSA", "kid": k.kid, "use": "sig", "n": enc(key.N), "e": enc(big.NewInt(int64(key.E)))}
+	case *ecdsa.PrivateKey:
+		return map[string]string{"kty": "EC", "kid": k.kid, "crv": "P-256", "x": enc(key.X), "y": enc(key.Y)}
+	}
+	panic("unsupported key")
+}
+
+func (k testKey) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(k.method, claims)
+	if k.kid != "" {
+		token.Header["kid"] = k.kid
+	}
+	s, err := token.SignedString(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// 写入 JWKS 文件，修改时间设为 mtime，保证重写后能被识别为新版本
+func writeJWKS(t *testing.T, path string, mtime time.Time, keys ...testKey) {
+	t.Helper()
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	for _, k := range keys {
+		set.Keys = append(set.Keys, k.jwk())
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "alice",
+		"iss": "hello-issuer",
+		"aud": "hello-service",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func withClaim(key string, value any) jwt.MapClaims {
+	c := validClaims()
+	if value == nil {
+		delete(c, key)
+	} else {
+		c[key] = value
+	}
+	return c
+}
+
+func TestJWTVerify(t *testing.T) {
+	rsaKey := newRSAKey(t, "rsa-1")
+	ecKey := newECKey(t, "ec-1")
+	otherKey := newRSAKey(t, "rsa-1")
+	unknownKey := newRSAKey(t, "rsa-2")
+	hmacKey := testKey{method: jwt.SigningMethodHS256, key: []byte("secret")}
+
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwks, time.Now(), rsaKey, ecKey)
+	base := JWTConfig{Issuer: "hello-issuer", Audience: "hello-service"}
+	jwksOnly, hmacOnly := base, base
+	jwksOnly.JWKSFile = jwks
+	hmacOnly.HMACSecret = "secret"
+
+	noneToken, err := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 用 RSA 公钥的 JWK 内容作 HMAC 密钥，模拟算法混淆攻击
+	confusion := testKey{method: jwt.SigningMethodHS256, key: []byte(rsaKey.jwk()["n"])}
+
+	tests := []struct {
+		name    string
+		cfg     JWTConfig
+		token   string
+		wantErr bool
+	}{
+		{"RS256", jwksOnly, rsaKey.sign(t, validClaims()), false},
+		{"ES256", jwksOnly, ecKey.sign(t, validClaims()), false},
+		{"HS256", hmacOnly, hmacKey.sign(t, validClaims()), false},
+		{"expired", jwksOnly, rsaKey.sign(t, withClaim("exp", time.Now().Add(-time.Minute).Unix())), true},
+		{"missing exp", jwksOnly, rsaKey.sign(t, withClaim("exp", nil)), true},
+		{"not yet valid", jwksOnly, rsaKey.sign(t, withClaim("nbf", time.Now().Add(time.Hour).Unix())), true},
+		{"HS256 without HMAC secret", jwksOnly, hmacKey.sign(t, validClaims()), true},
+		{"HS256 with public key", jwksOnly, confusion.sign(t, validClaims()), true},
+		{"RS256 without JWKS", hmacOnly, rsaKey.sign(t, validClaims()), true},
+		{"alg none", jwksOnly, noneToken, true},
+		{"alg none with HMAC", hmacOnly, noneToken, true},
+		{"wrong HMAC secret", hmacOnly, testKey{method: jwt.SigningMethodHS256, key: []byte("other")}.sign(t, validClaims()), true},
+		{"wrong issuer", jwksOnly, rsaKey.sign(t, withClaim("iss", "other-issuer")), true},
+		{"missing issuer", jwksOnly, rsaKey.sign(t, withClaim("iss", nil)), true},
+		{"wrong audience", jwksOnly, rsaKey.sign(t, withClaim("aud", "other-service")), true},
+		{"wrong signing key", jwksOnly, otherKey.sign(t, validClaims()), true},
+		{"unknown kid", jwksOnly, unknownKey.sign(t, validClaims()), true},
+		// JWKS 中有多个密钥时必须带 kid
+		{"missing kid", jwksOnly, testKey{method: jwt.SigningMethodRS256, key: rsaKey.key}.sign(t, validClaims()), true},
+		{"malformed", jwksOnly, "not-a-token", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := newJWTVerifier(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := v.verify(tt.token)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && claims["sub"] != "alice" {
+				t.Errorf("sub = %v, want alice", claims["sub"])
+			}
+		})
+	}
+}
+
+func TestJWKSSingleKeyWithoutKid(t *testing.T) {
+	key := newRSAKey(t, "rsa-1")
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwks, time.Now(), key)
+	v, err := newJWTVerifier(JWTConfig{JWKSFile: jwks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.verify(testKey{method: jwt.SigningMethodRS256, key: key.key}.sign(t, validClaims())); err != nil {
+		t.Errorf("token without kid rejected: %v", err)
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	oldKey := newRSAKey(t, "key-1")
+	newKey := newRSAKey(t, "key-2")
+	jwks := filepath.Join(t.TempDir(), "jwks.json")
+	mtime := time.Now().Add(-time.Hour)
+	writeJWKS(t, jwks, mtime, oldKey)
+
+	v, err := newJWTVerifier(JWTConfig{JWKSFile: jwks})
+	if err != nil {
+		t.Fatal(err)
+	}
+	newToken := newKey.sign(t, validClaims())
+	if _, err := v.verify(newToken); err == nil {
+		t.Fatal("token signed by a key not in the JWKS accepted")
+	}
+
+	// 轮换：新文件同时包含新旧密钥
+	mtime = mtime.Add(time.Minute)
+	writeJWKS(t, jwks, mtime, oldKey, newKey)
+	if _, err := v.verify(newToken); err != nil {
+		t.Fatalf("token signed by the rotated key rejected: %v", err)
+	}
+
+	// 新文件无效时保留原有密钥
+	mtime = mtime.Add(time.Minute)
+	if err := os.WriteFile(jwks, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(jwks, mtime, mtime)
+	if _, err := v.verify(newKey.sign(t, validClaims())); err != nil {
+		t.Errorf("previous keys dropped after an invalid JWKS update: %v", err)
+	}
+	if _, err := v.verify(newRSAKey(t, "key-3").sign(t, validClaims())); err == nil {
+		t.Error("unknown kid accepted after an invalid JWKS update")
+	}
+
+	// 旧密钥从文件中移除后不再接受
+	mtime = mtime.Add(time.Minute)
+	writeJWKS(t, jwks, mtime, newKey)
+	if _, err := v.verify(newRSAKey(t, "key-4").sign(t, validClaims())); err == nil {
+		t.Error("unknown kid accepted")
+	}
+	if _, err := v.verify(oldKey.sign(t, validClaims())); err == nil {
+		t.Error("token signed by a removed key accepted")
+	}
+}
+
+func TestReadJWKSErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"invalid JSON", "{"},
+		{"no keys", `{"keys": []}`},
+		{"encryption keys only", `{"keys": [{"kty": "RSA", "kid": "k", "use": "enc", "n": "AQAB", "e": "AQAB"}]}`},
+		{"unsupported key type", `{"keys": [{"kty": "oct", "kid": "k"}]}`},
+		{"unsupported curve", `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-224", "x": "AQ", "y": "AQ"}]}`},
+		{"point not on curve", `{"keys": [{"kty": "EC", "kid": "k", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`},
+		{"invalid modulus", `{"keys": [{"kty": "RSA", "kid": "k", "n": "!", "e": "AQAB"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "jwks.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := newJWTVerifier(JWTConfig{JWKSFile: path}); err == nil {
+				t.Error("newJWTVerifier succeeded, want error")
+			}
+		})
+	}
+	if _, err := newJWTVerifier(JWTConfig{JWKSFile: filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("newJWTVerifier succeeded with a missing JWKS file")
+	}
+}
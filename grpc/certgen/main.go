@@ -0,0 +1,132 @@
+// certgen 生成本地测试用的 CA、服务端证书和客户端证书，证书中带有 SPIFFE ID：
+//
+//	go run ./certgen -out certs -trust-domain example.org
+//
+// 生成 ca.pem、server.pem/server-key.pem（spiffe://<trust-domain>/hello-service，
+// 同时可作为客户端证书用于网关回环连接）和 client.pem/client-key.pem（spiffe://<trust-domain>/client）
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type keyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return serial
+}
+
+// 用 parent 签发证书，parent 为 nil 时自签名
+func issue(template *x509.Certificate, parent *keyPair) *keyPair {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	signer, signerCert := key, template
+	if parent != nil {
+		signer, signerCert = parent.key, parent.cert
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCert, &key.PublicKey, signer)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return &keyPair{cert: cert, key: key}
+}
+
+func writePEM(path, blockType string, der []byte, mode os.FileMode) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(path, data, mode); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func save(dir, name string, kp *keyPair) {
+	writePEM(filepath.Join(dir, name+".pem"), "CERTIFICATE", kp.cert.Raw, 0o644)
+	key, err := x509.MarshalECPrivateKey(kp.key)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	writePEM(filepath.Join(dir, name+"-key.pem"), "EC PRIVATE KEY", key, 0o600)
+}
+
+func spiffeURI(trustDomain, path string) *url.URL {
+	return &url.URL{Scheme: "spiffe", Host: trustDomain, Path: path}
+}
+
+func main() {
+	out := flag.String("out", "certs", "output directory")
+	trustDomain := flag.String("trust-domain", "example.org", "SPIFFE trust domain")
+	validity := flag.Duration("validity", 365*24*time.Hour, "certificate validity")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		log.Fatalln(err)
+	}
+
+	now := time.Now()
+	ca := issue(&x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "hello-service test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(*validity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil)
+
+	server := issue(&x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: "hello-service"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(*validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		URIs:         []*url.URL{spiffeURI(*trustDomain, "/hello-service")},
+	}, ca)
+
+	client := issue(&x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(*validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		URIs:         []*url.URL{spiffeURI(*trustDomain, "/client")},
+	}, ca)
+
+	writePEM(filepath.Join(*out, "ca.pem"), "CERTIFICATE", ca.cert.Raw, 0o644)
+	save(*out, "server", server)
+	save(*out, "client", client)
+
+	fmt.Printf("Certificates written to %s\n", *out)
+	fmt.Printf("  server: %s\n", server.cert.URIs[0])
+	fmt.Printf("  client: %s\n", client.cert.URIs[0])
+}
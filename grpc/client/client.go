@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/resolver"
+	"test/grpc/auth"
+	"test/grpc/discovery"
 	ecpb "test/grpc/hello"
+	"test/grpc/policy"
+	"test/grpc/security"
+	"test/grpc/tracing"
 )
 
 const (
@@ -20,7 +26,11 @@ const (
 )
 
 func callUnaryEcho(c ecpb.HelloServiceClient, message string) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// 客户端 span 是 gRPC 调用 span 的父 span，traceparent 随请求传到服务端
+	ctx, span := tracing.Tracer().Start(context.Background(), "callUnaryEcho")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
 	defer cancel()
 	r, err := c.SayHello(ctx, &ecpb.HelloRequest{Name: message})
 	if err != nil {
@@ -37,9 +47,38 @@ func makeRPCs(cc *grpc.ClientConn, n int) {
 }
 
 func main() {
+	// 指定 -tls-ca 时使用 TLS，再指定 -tls-cert/-tls-key 时使用 mTLS：
+	//	go run ./client -tls-ca certs/ca.pem -tls-cert certs/client.pem -tls-key certs/client-key.pem
+	var tlsCfg security.Config
+	tlsCfg.AddFlags(flag.CommandLine)
+	// 服务端开启认证时用 -token 或 -api-key 携带凭据
+	var callCreds auth.CallCredentials
+	callCreds.AddFlags(flag.CommandLine)
+	// 指定 -trace-exporter stdout 时把 span 打印到标准输出，otlp 时发送到 -otlp-endpoint
+	var tracingCfg tracing.Config
+	tracingCfg.AddFlags(flag.CommandLine)
+	flag.Parse()
+	callCreds.AllowInsecure = !tlsCfg.Enabled()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingCfg, "hello-client")
+	if err != nil {
+		log.Fatalf("Invalid tracing config: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	creds, closeCreds, err := security.DialCredentials(tlsCfg)
+	if err != nil {
+		log.Fatalf("invalid TLS config: %v", err)
+	}
+	defer closeCreds()
+	dialOpts := append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, callCreds.DialOptions()...)
+	if tracingCfg.Enabled() {
+		dialOpts = append(dialOpts, tracing.DialOption())
+	}
+
 	passthroughConn, err := grpc.NewClient(
 		fmt.Sprintf("passthrough:///%s", backendAddr), // Dial to "passthrough:///localhost:50051"
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		dialOpts...,
 	)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
@@ -51,9 +90,24 @@ func main() {
 
 	fmt.Println()
 
+	// SayHello 100ms 内没有返回就再发一个对冲请求，最多 3 个，取最先成功的结果
+	hedgingPolicy := policy.Config{
+		Methods: []string{"/" + ecpb.HelloService_ServiceDesc.ServiceName + "/SayHello"},
+		Hedging: &policy.HedgingPolicy{
+			MaxAttempts:   3,
+			HedgingDelay:  100 * time.Millisecond,
+			NonFatalCodes: []codes.Code{codes.Unavailable},
+		},
+		Throttling: &policy.Throttling{MaxTokens: 10, TokenRatio: 0.1},
+	}
+	hedgingOpts, err := hedgingPolicy.DialOptions()
+	if err != nil {
+		log.Fatalf("invalid hedging policy: %v", err)
+	}
+
 	exampleConn, err := grpc.NewClient(
 		fmt.Sprintf("%s:///%s", exampleScheme, exampleServiceName), // Dial to "example:///resolver.example.grpc.io"
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		append(hedgingOpts, dialOpts...)...,
 	)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
@@ -64,38 +118,12 @@ func main() {
 	makeRPCs(exampleConn, 10)
 }
 
-type exampleResolverBuilder struct{}
-
-func (*exampleResolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
-	r := &exampleResolver{
-		target: target,
-		cc:     cc,
-		addrsStore: map[string][]string{
-			exampleServiceName: {backendAddr},
-		},
-	}
-	r.start()
-	return r, nil
-}
-func (*exampleResolverBuilder) Scheme() string { return exampleScheme }
-
-type exampleResolver struct {
-	target     resolver.Target
-	cc         resolver.ClientConn
-	addrsStore map[string][]string
-}
-
-func (r *exampleResolver) start() {
-	addrStrs := r.addrsStore[r.target.Endpoint()]
-	addrs := make([]resolver.Address, len(addrStrs))
-	for i, s := range addrStrs {
-		addrs[i] = resolver.Address{Addr: s}
-	}
-	r.cc.UpdateState(resolver.State{Addresses: addrs})
-}
-func (*exampleResolver) ResolveNow(resolver.ResolveNowOptions) {}
-func (*exampleResolver) Close()                                {}
-
 func init() {
-	resolver.Register(&exampleResolverBuilder{})
+	// 静态数据源，固定解析到 backendAddr
+	resolver.Register(discovery.NewBackendBuilder(
+		discovery.NewStaticBackend(map[string][]discovery.ServiceInfo{
+			exampleServiceName: {{Addr: backendAddr}},
+		}),
+		discovery.Options{Scheme: exampleScheme},
+	))
 }
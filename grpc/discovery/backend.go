@@ -0,0 +1,64 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/backoff"
+)
+
+// 数据源推送给 resolver 的服务状态
+type Update struct {
+	// 服务的完整实例列表，key 在数据源内唯一（etcd key、文件中的实例 ID 等）
+	Instances map[string]ServiceInfo
+	// 服务的 gRPC service config JSON，为空表示没有配置
+	ServiceConfig string
+	// 数据源暂时不可用，此时忽略 Instances，resolver 继续使用已有数据
+	Err error
+}
+
+// Backend 是服务实例的数据源。Watch 持续把服务的最新状态通过 update 推送给 resolver，
+// 直到 ctx 结束才返回；数据源出错时推送带 Err 的 Update 并自行重试
+type Backend interface {
+	Watch(ctx context.Context, serviceName string, update func(Update))
+}
+
+// 按第 retries 次重试的退避时间等待，ctx 结束时返回 false
+func sleepBackoff(ctx context.Context, cfg backoff.Config, retries int) bool {
+	delay := float64(cfg.BaseDelay) * math.Pow(cfg.Multiplier, float64(retries))
+	delay = min(delay, float64(cfg.MaxDelay))
+	delay *= 1 + cfg.Jitter*(rand.Float64()*2-1)
+
+	timer := time.NewTimer(time.Duration(delay))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// 静态实例列表，适合测试和本地开发
+type staticBackend struct {
+	services map[string][]ServiceInfo
+}
+
+// NewStaticBackend 使用固定的实例列表，key 为服务名
+func NewStaticBackend(services map[string][]ServiceInfo) Backend {
+	return &staticBackend{services: services}
+}
+
+func (b *staticBackend) Watch(ctx context.Context, serviceName string, update func(Update)) {
+	instances := make(map[string]ServiceInfo)
+	for i, info := range b.services[serviceName] {
+		instances[fmt.Sprintf("%d", i)] = info
+	}
+	update(Update{Instances: instances})
+
+	<-ctx.Done()
+}
@@ -0,0 +1,209 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	_ "google.golang.org/grpc/health" // 注册客户端健康检查（grpc.health.v1）
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// 平滑加权轮询负载均衡器名称
+const WeightedBalancerName = "smooth_weighted_round_robin"
+
+// 挂在 resolver.Address.BalancerAttributes 上的权重 key
+type weightAttrKey struct{}
+
+// 给地址附加权重（BalancerAttributes 不参与 SubConn 判等，权重变化不会导致重连）
+func setAddrWeight(addr resolver.Address, weight int) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(weightAttrKey{}, weight)
+	return addr
+}
+
+// 读取地址上的权重，未设置或非法时返回 1
+func getAddrWeight(addr resolver.Address) int {
+	w, _ := addr.BalancerAttributes.Value(weightAttrKey{}).(int)
+	if w <= 0 {
+		return 1
+	}
+	return w
+}
+
+func init() {
+	balancer.Register(&weightedBalancerBuilder{})
+}
+
+// 平滑加权轮询 balancer 构建器
+type weightedBalancerBuilder struct{}
+
+func (*weightedBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &weightedPickerBuilder{weights: make(map[string]int), detector: newOutlierDetector()}
+	return newBaseBalancer(WeightedBalancerName, cc, opts, pb, func(s balancer.ClientConnState) {
+		// base balancer 只在新建 SubConn 时保存地址，权重需要单独同步，
+		// 它每次都会重新生成 picker，所以 watch 到的权重变化会立即生效
+		pb.updateWeights(s.ResolverState.Addresses)
+
+		var outlier *outlierConfig
+		if cfg, ok := s.BalancerConfig.(*weightedConfig); ok {
+			outlier = cfg.outlier
+		}
+		pb.detector.update(addrStrings(s.ResolverState.Addresses), outlier)
+	})
+}
+
+func (*weightedBalancerBuilder) Name() string {
+	return WeightedBalancerName
+}
+
+// 负载均衡配置：{"loadBalancingConfig":[{"smooth_weighted_round_robin":{"outlierDetection":{...}}}]}
+type weightedConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	OutlierDetection *outlierDetectionConfig `json:"outlierDetection"`
+
+	outlier *outlierConfig
+}
+
+func (*weightedBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &weightedConfig{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse config: %v", WeightedBalancerName, err)
+	}
+
+	var err error
+	if cfg.outlier, err = cfg.OutlierDetection.parse(); err != nil {
+		return nil, fmt.Errorf("%s: %v", WeightedBalancerName, err)
+	}
+	return cfg, nil
+}
+
+type weightedPickerBuilder struct {
+	mu       sync.RWMutex
+	weights  map[string]int
+	detector *outlierDetector
+}
+
+func (pb *weightedPickerBuilder) updateWeights(addrs []resolver.Address) {
+	weights := make(map[string]int, len(addrs))
+	for _, addr := range addrs {
+		weights[addr.Addr] = getAddrWeight(addr)
+	}
+
+	pb.mu.Lock()
+	pb.weights = weights
+	pb.mu.Unlock()
+}
+
+func (pb *weightedPickerBuilder) weight(addr string) int {
+	pb.mu.RLock()
+	defer pb.mu.RUnlock()
+
+	if w, ok := pb.weights[addr]; ok {
+		return w
+	}
+	return 1
+}
+
+func (pb *weightedPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	var items []*weightedItem
+	for sc, scInfo := range info.ReadySCs {
+		addr := scInfo.Address.Addr
+		items = append(items, &weightedItem{sc: sc, addr: addr, weight: pb.weight(addr)})
+	}
+	return newWeightedPicker(items, pb.detector)
+}
+
+type weightedItem struct {
+	sc            balancer.SubConn
+	addr          string
+	weight        int
+	currentWeight int
+}
+
+// 平滑加权轮询（nginx 算法）：
+// 每次所有节点 currentWeight += weight，选出最大者，再减去总权重。
+// 被异常检测驱逐的节点不参与本轮计算，全部被驱逐时忽略驱逐状态
+type weightedPicker struct {
+	mu       sync.Mutex
+	items    []*weightedItem
+	detector *outlierDetector
+}
+
+func newWeightedPicker(items []*weightedItem, detector *outlierDetector) *weightedPicker {
+	return &weightedPicker{items: items, detector: detector}
+}
+
+func (p *weightedPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	p.mu.Lock()
+	best := p.pick(true)
+	if best == nil {
+		best = p.pick(false)
+	}
+	p.mu.Unlock()
+
+	addr := best.addr
+	return balancer.PickResult{
+		SubConn: best.sc,
+		Done: func(info balancer.DoneInfo) {
+			p.detector.record(addr, info.Err)
+		},
+	}, nil
+}
+
+func (p *weightedPicker) pick(skipEjected bool) *weightedItem {
+	var best *weightedItem
+	total := 0
+	for _, item := range p.items {
+		if skipEjected && p.detector.ejected(item.addr) {
+			continue
+		}
+		item.currentWeight += item.weight
+		total += item.weight
+		if best == nil || item.currentWeight > best.currentWeight {
+			best = item
+		}
+	}
+	if best != nil {
+		best.currentWeight -= total
+	}
+	return best
+}
+
+// 本包各负载均衡器共用的外层：SubConn 和健康检查交给 base balancer 管理，
+// 地址或配置变化时先调用 update 同步到 picker 构建器，再由 base balancer 重新生成 picker
+type baseBalancer struct {
+	balancer.Balancer
+	cc     balancer.ClientConn
+	update func(balancer.ClientConnState)
+}
+
+func newBaseBalancer(name string, cc balancer.ClientConn, opts balancer.BuildOptions, pb base.PickerBuilder, update func(balancer.ClientConnState)) balancer.Balancer {
+	return &baseBalancer{
+		Balancer: base.NewBalancerBuilder(name, pb, base.Config{HealthCheck: true}).Build(cc, opts),
+		cc:       cc,
+		update:   update,
+	}
+}
+
+func (b *baseBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	b.update(s)
+	err := b.Balancer.UpdateClientConnState(s)
+	reportNoAddresses(b.cc, s.ResolverState)
+	return err
+}
+
+func addrStrings(addrs []resolver.Address) []string {
+	result := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		result = append(result, addr.Addr)
+	}
+	return result
+}
@@ -0,0 +1,86 @@
+package discovery
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWeightedPickerOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]int
+		ejected []string
+		want    string
+	}{
+		// nginx 平滑加权轮询的经典序列，高权重节点不会连续被选中太多次
+		{"smooth", map[string]int{"a": 5, "b": 1, "c": 1}, nil, "aabacaa" + "aabacaa"},
+		{"equal", map[string]int{"a": 1, "b": 1, "c": 1}, nil, "abcabc"},
+		{"two to one", map[string]int{"a": 2, "b": 1}, nil, "abaaba"},
+		{"ejected", map[string]int{"a": 5, "b": 1, "c": 1}, []string{"a"}, "bcbcbc"},
+		// 全部被驱逐时忽略驱逐状态
+		{"all ejected", map[string]int{"a": 2, "b": 1}, []string{"a", "b"}, "abaaba"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := newOutlierDetector()
+			var items []*weightedItem
+			var addrs []string
+			for _, addr := range []string{"a", "b", "c"} {
+				if w, ok := tt.weights[addr]; ok {
+					items = append(items, &weightedItem{sc: testSubConn(addr), addr: addr, weight: w})
+					addrs = append(addrs, addr)
+				}
+			}
+			ejectAll(t, detector, addrs, tt.ejected)
+
+			p := newWeightedPicker(items, detector)
+			var got strings.Builder
+			for range len(tt.want) {
+				got.WriteString(pickAddr(t, p))
+			}
+			if got.String() != tt.want {
+				t.Errorf("picked %s, want %s", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+// 测试用的 SubConn，只用来区分选中的地址
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+func testSubConn(addr string) balancer.SubConn {
+	return &fakeSubConn{addr: addr}
+}
+
+func pickAddr(t *testing.T, p balancer.Picker) string {
+	t.Helper()
+	res, err := p.Pick(balancer.PickInfo{Ctx: context.Background()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.SubConn.(*fakeSubConn).addr
+}
+
+// 用连续失败驱逐 ejected 中的地址，驱逐比例不设上限
+func ejectAll(t *testing.T, d *outlierDetector, addrs, ejected []string) {
+	t.Helper()
+	cfg, err := (&outlierDetectionConfig{ConsecutiveFailures: 1, MaxEjectionPercent: 100}).parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.update(addrs, cfg)
+	for _, addr := range ejected {
+		d.record(addr, status.Error(codes.Unavailable, "unavailable"))
+		if !d.ejected(addr) {
+			t.Fatalf("%s not ejected", addr)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 服务默认 service config 在 etcd 中的 key 名，与实例 key 同级：
+// <KeyPrefix><service>/_config
+const serviceConfigKey = "_config"
+
+// 服务 service config 的完整 key
+func (o Options) configKey(serviceName string) string {
+	return o.servicePrefix(serviceName) + serviceConfigKey
+}
+
+// PutServiceConfig 把 gRPC service config JSON（负载均衡策略、重试策略、方法超时等）写入 etcd，
+// 客户端 resolver 通过 watch 实时生效
+func PutServiceConfig(ctx context.Context, etcdClient *clientv3.Client, serviceName, serviceConfig string, opts Options) error {
+	opts = opts.withDefaults()
+	if !json.Valid([]byte(serviceConfig)) {
+		return fmt.Errorf("invalid service config JSON for %s", serviceName)
+	}
+
+	key := opts.configKey(serviceName)
+	if _, err := etcdClient.Put(ctx, key, serviceConfig); err != nil {
+		return fmt.Errorf("failed to put service config: %v", err)
+	}
+
+	opts.Logger.Printf("Updated service config: %s", key)
+	return nil
+}
+
+// DeleteServiceConfig 删除服务的 service config，客户端回退到各自的默认配置
+func DeleteServiceConfig(ctx context.Context, etcdClient *clientv3.Client, serviceName string, opts Options) error {
+	opts = opts.withDefaults()
+	if _, err := etcdClient.Delete(ctx, opts.configKey(serviceName)); err != nil {
+		return fmt.Errorf("failed to delete service config: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+// Package discovery 提供基于 etcd 的服务注册，以及可插拔数据源（etcd、DNS SRV、本地文件、静态列表）的服务发现
+package discovery
+
+// 服务信息结构
+type ServiceInfo struct {
+	Addr     string            `json:"addr"`
+	Weight   int               `json:"weight"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// API 版本，非空时实例 key 为 <prefix><service>/<version>/<instance>
+	Version string `json:"version,omitempty"`
+}
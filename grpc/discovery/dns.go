@@ -0,0 +1,105 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNS SRV 数据源默认的轮询间隔
+const DefaultDNSInterval = 30 * time.Second
+
+// 基于 DNS SRV 记录的数据源，目标中的服务名即 SRV 记录名，
+// 如 dns-srv:///_grpc._tcp.hello.example.com
+type dnsBackend struct {
+	resolver *net.Resolver
+	interval time.Duration
+	opts     Options
+}
+
+// NewDNSBackend 按 interval 轮询 SRV 记录。按 RFC 2782 只使用 priority 值最小的一组记录，
+// 该组的 weight 作为实例权重；更低优先级的记录只在更高一组全部从 DNS 中移除后才会被使用
+func NewDNSBackend(interval time.Duration, opts Options) Backend {
+	if interval <= 0 {
+		interval = DefaultDNSInterval
+	}
+	return &dnsBackend{
+		resolver: net.DefaultResolver,
+		interval: interval,
+		opts:     opts.withDefaults(),
+	}
+}
+
+func (b *dnsBackend) Watch(ctx context.Context, serviceName string, update func(Update)) {
+	for retries := 0; ; {
+		instances, err := b.lookup(ctx, serviceName)
+		if err == nil {
+			retries = 0
+			update(Update{Instances: instances})
+		} else {
+			b.opts.Logger.Printf("Failed to look up SRV records for %s: %v", serviceName, err)
+			update(Update{Err: err})
+		}
+
+		// 查询失败时按退避重试，成功后按固定间隔轮询
+		if err != nil {
+			if !sleepBackoff(ctx, b.opts.Backoff, retries) {
+				return
+			}
+			retries++
+			continue
+		}
+
+		timer := time.NewTimer(b.interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (b *dnsBackend) lookup(ctx context.Context, name string) (map[string]ServiceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, b.interval)
+	defer cancel()
+
+	_, records, err := b.resolver.LookupSRV(ctx, "", "", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return srvInstances(records), nil
+}
+
+// 把 SRV 记录转换为实例列表，只保留 priority 值最小的一组。
+// 目标为 "." 的记录表示该服务明确不可用，不作为实例
+func srvInstances(records []*net.SRV) map[string]ServiceInfo {
+	var lowest uint16
+	found := false
+	for _, srv := range records {
+		if srv.Target == "." {
+			continue
+		}
+		if !found || srv.Priority < lowest {
+			lowest, found = srv.Priority, true
+		}
+	}
+
+	instances := make(map[string]ServiceInfo)
+	for _, srv := range records {
+		if srv.Target == "." || srv.Priority != lowest {
+			continue
+		}
+		addr := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		instances[addr] = ServiceInfo{
+			Addr:     addr,
+			Weight:   max(int(srv.Weight), 1),
+			Metadata: map[string]string{"priority": fmt.Sprint(srv.Priority)},
+		}
+	}
+	return instances
+}
@@ -0,0 +1,65 @@
+package discovery
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSRVInstances(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []*net.SRV
+		want    map[string]int
+	}{
+		{
+			name: "only lowest priority",
+			records: []*net.SRV{
+				{Target: "b.example.com.", Port: 80, Priority: 20, Weight: 5},
+				{Target: "a1.example.com.", Port: 80, Priority: 10, Weight: 3},
+				{Target: "a2.example.com.", Port: 80, Priority: 10, Weight: 1},
+			},
+			want: map[string]int{"a1.example.com:80": 3, "a2.example.com:80": 1},
+		},
+		{
+			name: "zero weight",
+			records: []*net.SRV{
+				{Target: "a.example.com.", Port: 80, Priority: 0, Weight: 0},
+			},
+			want: map[string]int{"a.example.com:80": 1},
+		},
+		{
+			name: "service unavailable",
+			records: []*net.SRV{
+				{Target: ".", Port: 0, Priority: 0, Weight: 0},
+			},
+			want: map[string]int{},
+		},
+		{
+			name: "unavailable target ignored",
+			records: []*net.SRV{
+				{Target: ".", Port: 0, Priority: 0},
+				{Target: "b.example.com.", Port: 81, Priority: 5, Weight: 2},
+			},
+			want: map[string]int{"b.example.com:81": 2},
+		},
+		{
+			name: "no records",
+			want: map[string]int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]int)
+			for addr, info := range srvInstances(tt.records) {
+				if info.Addr != addr {
+					t.Errorf("instance %s has addr %s", addr, info.Addr)
+				}
+				got[addr] = info.Weight
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("srvInstances() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
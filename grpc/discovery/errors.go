@@ -0,0 +1,77 @@
+package discovery
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	errorDomain       = "discovery"
+	reasonNoInstances = "NO_INSTANCES"
+)
+
+// 服务没有可用实例时 RPC 立即失败并返回的状态：
+// codes.Unavailable 加 ErrorInfo 详情，与 etcd 故障等其他不可用原因区分，可用 IsNoInstances 判断
+func noInstancesError(serviceName string, registered int) error {
+	msg := fmt.Sprintf("service %q has no registered instances", serviceName)
+	if registered > 0 {
+		msg = fmt.Sprintf("none of the %d registered instances of service %q match the target", registered, serviceName)
+	}
+
+	st, err := status.New(codes.Unavailable, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reasonNoInstances,
+		Domain:   errorDomain,
+		Metadata: map[string]string{"service": serviceName},
+	})
+	if err != nil {
+		return status.Error(codes.Unavailable, msg)
+	}
+	return st.Err()
+}
+
+// IsNoInstances 判断 RPC 错误是否因为服务没有可用实例
+func IsNoInstances(err error) bool {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return false
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.Domain == errorDomain && info.Reason == reasonNoInstances {
+			return true
+		}
+	}
+	return false
+}
+
+// resolver 下发空地址列表时通过 State.Attributes 附带的原因
+type stateErrAttrKey struct{}
+
+func setStateError(state resolver.State, err error) resolver.State {
+	state.Attributes = state.Attributes.WithValue(stateErrAttrKey{}, err)
+	return state
+}
+
+func getStateError(state resolver.State) error {
+	err, _ := state.Attributes.Value(stateErrAttrKey{}).(error)
+	return err
+}
+
+// base balancer 对空地址列表只给出通用错误，这里换成 resolver 给出的状态，让 RPC 立即失败
+func reportNoAddresses(cc balancer.ClientConn, state resolver.State) {
+	if len(state.Addresses) > 0 {
+		return
+	}
+	if err := getStateError(state); err != nil {
+		cc.UpdateState(balancer.State{
+			ConnectivityState: connectivity.TransientFailure,
+			Picker:            base.NewErrPicker(err),
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+)
+
+// 单次 etcd 请求超时
+const etcdRequestTimeout = 5 * time.Second
+
+// 主动请求 watch 进度的间隔。etcd 不可达时客户端会在内部无限重连，watch 通道既不报错也不关闭，
+// 请求进度后超过 etcdRequestTimeout 仍没有任何响应就认为已与 etcd 失联
+const etcdProgressInterval = 10 * time.Second
+
+// 基于 etcd 的数据源：全量读取一次后按 revision 增量 watch
+type etcdBackend struct {
+	etcdClient *clientv3.Client
+	opts       Options
+}
+
+// NewEtcdBackend 从 etcd 读取 <KeyPrefix><service>/ 下的实例和 service config
+func NewEtcdBackend(etcdClient *clientv3.Client, opts Options) Backend {
+	return &etcdBackend{
+		etcdClient: etcdClient,
+		opts:       opts.withDefaults(),
+	}
+}
+
+func (b *etcdBackend) Watch(ctx context.Context, serviceName string, update func(Update)) {
+	w := &etcdWatcher{
+		etcdBackend:   b,
+		ctx:           ctx,
+		serviceName:   serviceName,
+		servicePrefix: b.opts.servicePrefix(serviceName),
+		configKey:     b.opts.configKey(serviceName),
+		update:        update,
+		instances:     make(map[string]ServiceInfo),
+	}
+	w.run()
+}
+
+// 单个服务的 watch 状态
+type etcdWatcher struct {
+	*etcdBackend
+	ctx           context.Context
+	serviceName   string
+	servicePrefix string
+	configKey     string
+	update        func(Update)
+
+	instances     map[string]ServiceInfo
+	serviceConfig string
+	// 已向 resolver 推送错误，恢复后需要重新推送缓存以清除过期标记
+	failed bool
+}
+
+func (w *etcdWatcher) run() {
+	// 全量同步一次，之后从该 revision 开始增量监听
+	rev, ok := w.resync()
+	if !ok {
+		return
+	}
+
+	// 连接的 etcd 节点失去 leader 时（如网络分区）服务端取消 watch，而不是让它停在旧数据上
+	watchCtx := clientv3.WithRequireLeader(w.ctx)
+	for retries := 0; w.ctx.Err() == nil; {
+		// 监听 etcd 变化，从 rev+1 开始保证不丢事件
+		watchChan := w.etcdClient.Watch(watchCtx, w.servicePrefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+
+		var progressed bool
+		if rev, progressed = w.watch(watchCtx, watchChan, rev); progressed {
+			retries = 0
+			continue
+		}
+
+		// watch 没有收到任何有效响应就结束了，退避后再重建
+		if !sleepBackoff(w.ctx, w.opts.Backoff, retries) {
+			return
+		}
+		retries++
+	}
+}
+
+// 全量同步直到成功，失败时推送错误并按退避重试；ctx 结束时返回 false
+func (w *etcdWatcher) resync() (int64, bool) {
+	for retries := 0; ; retries++ {
+		rev, err := w.load()
+		if err == nil {
+			w.push()
+			return rev, true
+		}
+
+		w.opts.Logger.Printf("Failed to get services from etcd: %v", err)
+		w.fail(err)
+
+		if !sleepBackoff(w.ctx, w.opts.Backoff, retries) {
+			return 0, false
+		}
+	}
+}
+
+// 处理一个 watch 通道直到其关闭，返回已处理到的 revision 以及期间是否有进展。
+// 期间定期请求进度，没有按时收到响应时向 resolver 推送错误，收到后再恢复
+func (w *etcdWatcher) watch(watchCtx context.Context, watchChan clientv3.WatchChan, rev int64) (int64, bool) {
+	ticker := time.NewTicker(etcdProgressInterval)
+	defer ticker.Stop()
+	// 进度请求的响应期限，没有未完成的请求时为 nil
+	var deadline <-chan time.Time
+
+	progressed := false
+	for {
+		select {
+		case watchResp, ok := <-watchChan:
+			if !ok {
+				return rev, progressed
+			}
+			deadline = nil
+			if watchResp.CompactRevision != 0 {
+				// 监听的 revision 已被压缩，事件无法补齐，只能全量重新同步
+				w.opts.Logger.Printf("Watch revision %d compacted (compact revision %d), resyncing...", rev+1, watchResp.CompactRevision)
+				return w.resync()
+			}
+			if err := watchResp.Err(); err != nil {
+				w.opts.Logger.Printf("Watch error: %v", err)
+				w.fail(err)
+				continue
+			}
+
+			progressed = true
+			rev = watchResp.Header.Revision
+			if watchResp.IsProgressNotify() {
+				// 进度通知说明该 revision 之前的事件都已收到，缓存仍是最新的
+				if w.failed {
+					w.push()
+				}
+				continue
+			}
+			w.applyEvents(watchResp.Events)
+			w.push()
+
+		case <-ticker.C:
+			if deadline == nil {
+				deadline = time.After(etcdRequestTimeout)
+				go w.requestProgress(watchCtx)
+			}
+
+		case <-deadline:
+			deadline = nil
+			err := fmt.Errorf("etcd watch made no progress within %v", etcdRequestTimeout)
+			w.opts.Logger.Printf("Watch error: %v", err)
+			w.fail(err)
+
+		case <-w.ctx.Done():
+			return rev, progressed
+		}
+	}
+}
+
+// 请求 etcd 在 watch 流上返回进度通知。ctx 须与 Watch 使用的一致（同一 metadata），才会发到同一条流上
+func (w *etcdWatcher) requestProgress(watchCtx context.Context) {
+	ctx, cancel := context.WithTimeout(watchCtx, etcdRequestTimeout)
+	defer cancel()
+	if err := w.etcdClient.RequestProgress(ctx); err != nil && w.ctx.Err() == nil {
+		w.opts.Logger.Printf("Failed to request watch progress: %v", err)
+	}
+}
+
+// 全量读取服务列表重建缓存，返回读取时的 revision
+func (w *etcdWatcher) load() (int64, error) {
+	// etcd 不可用时客户端会一直等待连接，需要单独的超时才能及时回退到快照
+	ctx, cancel := context.WithTimeout(w.ctx, etcdRequestTimeout)
+	defer cancel()
+	resp, err := w.etcdClient.Get(ctx, w.servicePrefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+
+	// 清空缓存
+	w.instances = make(map[string]ServiceInfo)
+	w.serviceConfig = ""
+
+	// 重新填充缓存
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if key == w.configKey {
+			w.serviceConfig = string(kv.Value)
+			continue
+		}
+		w.instances[key] = w.parseServiceInfo(key, kv.Value)
+	}
+	return resp.Header.Revision, nil
+}
+
+// 把 watch 事件增量应用到缓存
+func (w *etcdWatcher) applyEvents(events []*clientv3.Event) {
+	for _, ev := range events {
+		key := string(ev.Kv.Key)
+		if key == w.configKey {
+			w.serviceConfig = ""
+			if ev.Type == clientv3.EventTypePut {
+				w.serviceConfig = string(ev.Kv.Value)
+			}
+			w.opts.Logger.Printf("Service config of %s changed", w.serviceName)
+			continue
+		}
+
+		switch ev.Type {
+		case clientv3.EventTypePut:
+			w.instances[key] = w.parseServiceInfo(key, ev.Kv.Value)
+		case clientv3.EventTypeDelete:
+			delete(w.instances, key)
+		}
+	}
+	w.opts.Logger.Printf("Applied %d etcd events, %d instances cached", len(events), len(w.instances))
+}
+
+func (w *etcdWatcher) parseServiceInfo(key string, value []byte) ServiceInfo {
+	info := unmarshalServiceInfo(value)
+	// 版本以 key 中的层级为准
+	info.Version = w.opts.keyVersion(w.serviceName, key)
+	return info
+}
+
+// 把当前缓存推送给 resolver，resolver 会持有该 map，因此每次推送一份副本
+func (w *etcdWatcher) push() {
+	instances := make(map[string]ServiceInfo, len(w.instances))
+	for k, v := range w.instances {
+		instances[k] = v
+	}
+	w.failed = false
+	w.update(Update{Instances: instances, ServiceConfig: w.serviceConfig})
+}
+
+// 推送错误，resolver 保留现有数据并标记为过期
+func (w *etcdWatcher) fail(err error) {
+	w.failed = true
+	w.update(Update{Err: err})
+}
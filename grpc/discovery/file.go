@@ -0,0 +1,128 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// 本地文件数据源的内容，按扩展名使用 YAML（.yaml/.yml）或 JSON：
+//
+//	services:
+//	  hello-service:
+//	    config: '{"loadBalancingConfig": [{"round_robin": {}}]}'
+//	    instances:
+//	      - id: instance1
+//	        addr: localhost:8080
+//	        weight: 3
+//	        version: v1.0.0
+//	        metadata: {region: us-west, zone: a}
+type fileContent struct {
+	Services map[string]fileService `json:"services" yaml:"services"`
+}
+
+type fileService struct {
+	Config    string         `json:"config" yaml:"config"`
+	Instances []fileInstance `json:"instances" yaml:"instances"`
+}
+
+type fileInstance struct {
+	// 实例 ID，省略时使用地址
+	ID          string `json:"id" yaml:"id"`
+	ServiceInfo `yaml:",inline"`
+}
+
+// 监听本地文件的数据源，文件变化后自动重新加载，适合测试和本地开发
+type fileBackend struct {
+	path string
+	opts Options
+}
+
+// NewFileBackend 从 YAML 或 JSON 文件读取实例列表
+func NewFileBackend(path string, opts Options) Backend {
+	return &fileBackend{path: path, opts: opts.withDefaults()}
+}
+
+func (b *fileBackend) Watch(ctx context.Context, serviceName string, update func(Update)) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		update(Update{Err: fmt.Errorf("failed to watch %s: %v", b.path, err)})
+		<-ctx.Done()
+		return
+	}
+	defer watcher.Close()
+
+	// 监听所在目录而不是文件本身，编辑器保存时常常是先写临时文件再重命名
+	if err := watcher.Add(filepath.Dir(b.path)); err != nil {
+		update(Update{Err: fmt.Errorf("failed to watch %s: %v", b.path, err)})
+		<-ctx.Done()
+		return
+	}
+
+	b.load(serviceName, update)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != filepath.Clean(b.path) || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) {
+				continue
+			}
+			b.opts.Logger.Printf("Services file %s changed, reloading...", b.path)
+			b.load(serviceName, update)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			b.opts.Logger.Printf("Watch %s error: %v", b.path, err)
+		}
+	}
+}
+
+func (b *fileBackend) load(serviceName string, update func(Update)) {
+	content, err := readServicesFile(b.path)
+	if err != nil {
+		b.opts.Logger.Printf("Failed to load services file: %v", err)
+		update(Update{Err: err})
+		return
+	}
+
+	svc := content.Services[serviceName]
+	instances := make(map[string]ServiceInfo, len(svc.Instances))
+	for _, inst := range svc.Instances {
+		id := inst.ID
+		if id == "" {
+			id = inst.Addr
+		}
+		instances[id] = inst.ServiceInfo
+	}
+	update(Update{Instances: instances, ServiceConfig: svc.Config})
+}
+
+func readServicesFile(path string) (*fileContent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var content fileContent
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &content)
+	default:
+		err = json.Unmarshal(data, &content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return &content, nil
+}
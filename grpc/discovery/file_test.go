@@ -0,0 +1,157 @@
+package discovery
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func writeServicesFile(t *testing.T, path, content string) {
+	t.Helper()
+	// 先写临时文件再重命名，和编辑器保存文件的方式一致，也避免读到写了一半的内容
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 在后台运行 Watch，返回接收更新的 channel
+func watchFile(t *testing.T, path string) <-chan Update {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	updates := make(chan Update, 16)
+	b := NewFileBackend(path, Options{Logger: log.New(io.Discard, "", 0)})
+	go func() {
+		defer close(done)
+		b.Watch(ctx, "hello-service", func(u Update) {
+			select {
+			case updates <- u:
+			case <-ctx.Done():
+			}
+		})
+	}()
+	return updates
+}
+
+// 跳过内容相同的重复事件，等待满足 cond 的更新
+func waitUpdate(t *testing.T, updates <-chan Update, cond func(Update) bool) Update {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case u := <-updates:
+			if cond(u) {
+				return u
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for update")
+			return Update{}
+		}
+	}
+}
+
+// 按地址排序，便于比较
+func sortedAddrs(instances map[string]ServiceInfo) []string {
+	var addrs []string
+	for _, info := range instances {
+		addrs = append(addrs, info.Addr)
+	}
+	slices.Sort(addrs)
+	return addrs
+}
+
+func TestFileBackendReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.yaml")
+	writeServicesFile(t, path, `
+services:
+  hello-service:
+    config: '{"loadBalancingConfig": [{"round_robin": {}}]}'
+    instances:
+      - id: instance1
+        addr: localhost:8080
+        weight: 3
+        metadata: {region: us-west}
+      - addr: localhost:8081
+`)
+	updates := watchFile(t, path)
+
+	u := waitUpdate(t, updates, func(Update) bool { return true })
+	if u.Err != nil {
+		t.Fatalf("initial load failed: %v", u.Err)
+	}
+	if got, want := sortedAddrs(u.Instances), []string{"localhost:8080", "localhost:8081"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("initial instances = %v, want %v", got, want)
+	}
+	if info := u.Instances["instance1"]; info.Weight != 3 || info.Metadata["region"] != "us-west" {
+		t.Errorf("instance1 = %+v, want weight 3 and region us-west", info)
+	}
+	if _, ok := u.Instances["localhost:8081"]; !ok {
+		t.Errorf("instance without id should be keyed by addr, got %v", u.Instances)
+	}
+	if u.ServiceConfig == "" {
+		t.Error("service config not loaded")
+	}
+
+	writeServicesFile(t, path, `
+services:
+  hello-service:
+    instances:
+      - addr: localhost:8082
+`)
+	u = waitUpdate(t, updates, func(u Update) bool {
+		_, ok := u.Instances["localhost:8082"]
+		return ok
+	})
+	if got, want := sortedAddrs(u.Instances), []string{"localhost:8082"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("reloaded instances = %v, want %v", got, want)
+	}
+	if u.ServiceConfig != "" {
+		t.Errorf("service config = %q after it was removed, want empty", u.ServiceConfig)
+	}
+}
+
+func TestFileBackendInvalidContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services.json")
+	writeServicesFile(t, path, `{"services": {"hello-service": {"instances": [{"addr": "localhost:8080"}]}}}`)
+	updates := watchFile(t, path)
+
+	if u := waitUpdate(t, updates, func(Update) bool { return true }); u.Err != nil {
+		t.Fatalf("initial load failed: %v", u.Err)
+	}
+
+	// 内容无效时只报告错误，不下发空列表，resolver 继续使用上一次的实例
+	writeServicesFile(t, path, `{"services": `)
+	u := waitUpdate(t, updates, func(u Update) bool { return u.Err != nil })
+	if u.Instances != nil {
+		t.Errorf("error update carries instances %v", u.Instances)
+	}
+
+	// 修复后恢复
+	writeServicesFile(t, path, `{"services": {"hello-service": {"instances": [{"addr": "localhost:8081"}]}}}`)
+	u = waitUpdate(t, updates, func(u Update) bool { return u.Err == nil })
+	if got, want := sortedAddrs(u.Instances), []string{"localhost:8081"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("recovered instances = %v, want %v", got, want)
+	}
+}
+
+func TestFileBackendMissingFile(t *testing.T) {
+	updates := watchFile(t, filepath.Join(t.TempDir(), "missing.yaml"))
+	if u := waitUpdate(t, updates, func(Update) bool { return true }); u.Err == nil {
+		t.Errorf("missing file: got instances %v, want error", u.Instances)
+	}
+}
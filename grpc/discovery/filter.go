@@ -0,0 +1,87 @@
+package discovery
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// 没有实例匹配过滤条件时的处理策略
+const (
+	fallbackNone = "none" // 不下发任何地址
+	fallbackAll  = "all"  // 退回使用全部实例
+)
+
+// 目标地址中保留给 resolver 自身的参数，与 version 参数之外的参数都视为元数据过滤条件
+const fallbackParam = "fallback"
+
+// 按 ServiceInfo.Metadata 过滤实例，
+// 如 custom-etcd:///hello-service?region=us-west&zone=a&fallback=all
+type metadataFilter struct {
+	// 元数据 key -> 可接受的值（同一个 key 出现多次表示任一匹配即可）
+	match    map[string][]string
+	fallback string
+}
+
+func parseMetadataFilter(query url.Values) (*metadataFilter, error) {
+	f := &metadataFilter{
+		match:    make(map[string][]string),
+		fallback: fallbackNone,
+	}
+
+	for key, values := range query {
+		if key == fallbackParam || key == versionParam {
+			continue
+		}
+		f.match[key] = values
+	}
+
+	if v := query.Get(fallbackParam); v != "" {
+		switch v {
+		case fallbackNone, fallbackAll:
+			f.fallback = v
+		default:
+			return nil, fmt.Errorf("invalid fallback policy %q, want %q or %q", v, fallbackNone, fallbackAll)
+		}
+	}
+	return f, nil
+}
+
+func (f *metadataFilter) matches(info ServiceInfo) bool {
+	for key, values := range f.match {
+		got, ok := info.Metadata[key]
+		if !ok {
+			return false
+		}
+
+		matched := false
+		for _, v := range values {
+			if v == got {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// 返回匹配的实例；没有匹配时按 fallback 策略处理
+func (f *metadataFilter) apply(infos []ServiceInfo) []ServiceInfo {
+	if len(f.match) == 0 {
+		return infos
+	}
+
+	var result []ServiceInfo
+	for _, info := range infos {
+		if f.matches(info) {
+			result = append(result, info)
+		}
+	}
+
+	if len(result) == 0 && f.fallback == fallbackAll {
+		return infos
+	}
+	return result
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
+)
+
+// 实例信息写入 etcd 时使用的格式，读取时两种格式都能识别
+type Format int
+
+const (
+	// 本包的 ServiceInfo JSON：{"addr":"...","weight":3,"metadata":{...}}
+	FormatServiceInfo Format = iota
+	// etcd 官方 naming/endpoints 格式：{"Addr":"...","Metadata":{...}}，
+	// 权重写在 Metadata["weight"] 中。配合 KeyPrefix 使用时，
+	// endpoints.NewManager(client, "/services/hello-service") 注册的实例可直接被本包发现
+	FormatEndpoints
+)
+
+// 元数据中保存权重的 key（仅 endpoints 格式）
+const weightMetadataKey = "weight"
+
+func marshalServiceInfo(info ServiceInfo, format Format) ([]byte, error) {
+	switch format {
+	case FormatServiceInfo:
+		return json.Marshal(info)
+	case FormatEndpoints:
+		metadata := make(map[string]any, len(info.Metadata)+1)
+		for k, v := range info.Metadata {
+			metadata[k] = v
+		}
+		if info.Weight > 0 {
+			metadata[weightMetadataKey] = info.Weight
+		}
+		return json.Marshal(endpoints.Endpoint{Addr: info.Addr, Metadata: metadata})
+	default:
+		return nil, fmt.Errorf("unknown service info format %d", format)
+	}
+}
+
+// 解析 etcd 中的实例信息，兼容 ServiceInfo、官方 endpoints 以及纯地址字符串
+func unmarshalServiceInfo(value []byte) ServiceInfo {
+	// encoding/json 匹配字段名时不区分大小写，
+	// addr/Addr、metadata/Metadata 两种格式可以一次解析
+	var raw struct {
+		Addr     string          `json:"addr"`
+		Weight   int             `json:"weight"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if err := json.Unmarshal(value, &raw); err != nil || raw.Addr == "" {
+		// 如果不是 JSON 格式，创建默认信息
+		return ServiceInfo{
+			Addr:   string(value),
+			Weight: 1,
+		}
+	}
+
+	info := ServiceInfo{Addr: raw.Addr, Weight: raw.Weight}
+
+	// 官方格式的 Metadata 可以是任意 JSON，只保留对象中的字段
+	var metadata map[string]any
+	if err := json.Unmarshal(raw.Metadata, &metadata); err == nil && len(metadata) > 0 {
+		info.Metadata = make(map[string]string, len(metadata))
+		for k, v := range metadata {
+			switch v := v.(type) {
+			case string:
+				info.Metadata[k] = v
+			case float64:
+				info.Metadata[k] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				info.Metadata[k] = strconv.FormatBool(v)
+			}
+		}
+	}
+
+	if info.Weight == 0 {
+		if w, err := strconv.Atoi(info.Metadata[weightMetadataKey]); err == nil {
+			info.Weight = w
+		}
+	}
+	return info
+}
@@ -0,0 +1,264 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	v3orcapb "github.com/cncf/xds/go/xds/data/orca/v3"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	_ "google.golang.org/grpc/orca" // 解析响应 trailer 中的 ORCA 负载报告，填充 DoneInfo.ServerLoad
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// 按实时负载选择实例的负载均衡器名称
+const LeastLoadBalancerName = "least_load"
+
+// 负载计算方式
+const (
+	// 只看未完成的请求数
+	LeastLoadModeLeastRequest = "least_request"
+	// 未完成请求数乘以延迟的峰值 EWMA，延迟突增时立即生效，之后按 decayTime 逐渐回落
+	LeastLoadModePeakEWMA = "peak_ewma"
+)
+
+const defaultEWMADecayTime = 10 * time.Second
+
+// 尚未测得延迟但已有请求在途的实例的代价，保证新实例不会在测得延迟前被大量请求压垮
+const ewmaPenalty = float64(math.MaxInt32)
+
+// 负载均衡配置：
+// {"loadBalancingConfig":[{"least_load":{"mode":"peak_ewma","decayTime":"10s","useServerLoad":true}}]}
+type leastLoadConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	Mode      string `json:"mode"`
+	DecayTime string `json:"decayTime"`
+	// 使用服务端通过 ORCA 上报的利用率放大代价，利用率越高越少被选中
+	UseServerLoad bool `json:"useServerLoad"`
+
+	OutlierDetection *outlierDetectionConfig `json:"outlierDetection"`
+
+	decay   time.Duration
+	outlier *outlierConfig
+}
+
+func init() {
+	balancer.Register(&leastLoadBalancerBuilder{})
+}
+
+type leastLoadBalancerBuilder struct{}
+
+func (*leastLoadBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &leastLoadPickerBuilder{
+		config:   &leastLoadConfig{Mode: LeastLoadModePeakEWMA, decay: defaultEWMADecayTime},
+		stats:    make(map[string]*loadStats),
+		detector: newOutlierDetector(),
+	}
+	return newBaseBalancer(LeastLoadBalancerName, cc, opts, pb, func(s balancer.ClientConnState) {
+		cfg, _ := s.BalancerConfig.(*leastLoadConfig)
+		pb.update(s.ResolverState.Addresses, cfg)
+	})
+}
+
+func (*leastLoadBalancerBuilder) Name() string {
+	return LeastLoadBalancerName
+}
+
+func (*leastLoadBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &leastLoadConfig{Mode: LeastLoadModePeakEWMA, decay: defaultEWMADecayTime}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse config: %v", LeastLoadBalancerName, err)
+	}
+	if cfg.Mode != LeastLoadModeLeastRequest && cfg.Mode != LeastLoadModePeakEWMA {
+		return nil, fmt.Errorf("%s: unknown mode %q", LeastLoadBalancerName, cfg.Mode)
+	}
+	if cfg.DecayTime != "" {
+		d, err := time.ParseDuration(cfg.DecayTime)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("%s: invalid decayTime %q", LeastLoadBalancerName, cfg.DecayTime)
+		}
+		cfg.decay = d
+	}
+
+	var err error
+	if cfg.outlier, err = cfg.OutlierDetection.parse(); err != nil {
+		return nil, fmt.Errorf("%s: %v", LeastLoadBalancerName, err)
+	}
+	return cfg, nil
+}
+
+// 单个地址的实时负载，跨 picker 保留，SubConn 状态变化重建 picker 时不会丢失
+type loadStats struct {
+	mu          sync.Mutex
+	weight      int
+	outstanding int
+	// 延迟的峰值 EWMA（纳秒），0 表示还没有测得
+	ewma       float64
+	lastUpdate time.Time
+	// 服务端最近一次上报的利用率
+	serverUtil float64
+}
+
+// 根据一次请求的延迟更新峰值 EWMA：比当前值大时直接取新值，否则按时间衰减平滑
+func (s *loadStats) observe(rtt time.Duration, decay time.Duration, now time.Time) {
+	v := float64(rtt)
+	if v > s.ewma {
+		s.ewma = v
+	} else {
+		w := math.Exp(-float64(now.Sub(s.lastUpdate)) / float64(decay))
+		s.ewma = s.ewma*w + v*(1-w)
+	}
+	s.lastUpdate = now
+}
+
+// 代价越小越优先，已按权重和服务端利用率调整
+func (s *loadStats) cost(cfg *leastLoadConfig) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var c float64
+	switch {
+	case cfg.Mode == LeastLoadModeLeastRequest:
+		c = float64(s.outstanding)
+	case s.ewma == 0 && s.outstanding > 0:
+		c = ewmaPenalty + float64(s.outstanding)
+	default:
+		c = s.ewma * float64(s.outstanding+1)
+	}
+	if cfg.UseServerLoad {
+		c *= 1 + s.serverUtil
+	}
+	return c / float64(s.weight)
+}
+
+// 服务端上报的利用率，优先使用应用自定义的利用率，其次是 CPU 利用率
+func serverUtilization(load any) (float64, bool) {
+	report, ok := load.(*v3orcapb.OrcaLoadReport)
+	if !ok || report == nil {
+		return 0, false
+	}
+	if report.ApplicationUtilization > 0 {
+		return report.ApplicationUtilization, true
+	}
+	return report.CpuUtilization, true
+}
+
+type leastLoadPickerBuilder struct {
+	mu       sync.RWMutex
+	config   *leastLoadConfig
+	stats    map[string]*loadStats
+	detector *outlierDetector
+}
+
+func (pb *leastLoadPickerBuilder) update(addrs []resolver.Address, cfg *leastLoadConfig) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if cfg != nil {
+		pb.config = cfg
+	}
+	pb.detector.update(addrStrings(addrs), pb.config.outlier)
+
+	// 保留仍然存在的地址的统计，只更新权重
+	stats := make(map[string]*loadStats, len(addrs))
+	for _, addr := range addrs {
+		s, ok := pb.stats[addr.Addr]
+		if !ok {
+			s = &loadStats{}
+		}
+		s.mu.Lock()
+		s.weight = getAddrWeight(addr)
+		s.mu.Unlock()
+		stats[addr.Addr] = s
+	}
+	pb.stats = stats
+}
+
+func (pb *leastLoadPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	pb.mu.RLock()
+	defer pb.mu.RUnlock()
+
+	var items []*leastLoadItem
+	for sc, scInfo := range info.ReadySCs {
+		addr := scInfo.Address.Addr
+		s, ok := pb.stats[addr]
+		if !ok {
+			s = &loadStats{weight: 1}
+		}
+		items = append(items, &leastLoadItem{sc: sc, addr: addr, stats: s})
+	}
+	return &leastLoadPicker{items: items, config: pb.config, detector: pb.detector}
+}
+
+type leastLoadItem struct {
+	sc    balancer.SubConn
+	addr  string
+	stats *loadStats
+}
+
+// power of two choices：随机取两个实例，选代价更小的一个，
+// 既避开慢实例，又不会让所有客户端同时涌向同一个"最空闲"的实例
+type leastLoadPicker struct {
+	items    []*leastLoadItem
+	config   *leastLoadConfig
+	detector *outlierDetector
+}
+
+func (p *leastLoadPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	candidates := make([]*leastLoadItem, 0, len(p.items))
+	for _, item := range p.items {
+		if !p.detector.ejected(item.addr) {
+			candidates = append(candidates, item)
+		}
+	}
+	// 全部被驱逐时忽略驱逐状态
+	if len(candidates) == 0 {
+		candidates = p.items
+	}
+
+	chosen := candidates[rand.Intn(len(candidates))]
+	if len(candidates) > 1 {
+		i := rand.Intn(len(candidates) - 1)
+		if candidates[i] == chosen {
+			i = len(candidates) - 1
+		}
+		if other := candidates[i]; other.stats.cost(p.config) < chosen.stats.cost(p.config) {
+			chosen = other
+		}
+	}
+
+	s := chosen.stats
+	s.mu.Lock()
+	s.outstanding++
+	s.mu.Unlock()
+
+	start := time.Now()
+	addr := chosen.addr
+	return balancer.PickResult{
+		SubConn: chosen.sc,
+		Done: func(info balancer.DoneInfo) {
+			now := time.Now()
+			s.mu.Lock()
+			s.outstanding--
+			// 后端故障往往很快返回，不能让它拉低延迟估计
+			if !isBackendFailure(info.Err) {
+				s.observe(now.Sub(start), p.config.decay, now)
+			}
+			if util, ok := serverUtilization(info.ServerLoad); ok {
+				s.serverUtil = util
+			}
+			s.mu.Unlock()
+			p.detector.record(addr, info.Err)
+		},
+	}, nil
+}
@@ -0,0 +1,63 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoadStatsCost(t *testing.T) {
+	leastRequest := &leastLoadConfig{Mode: LeastLoadModeLeastRequest}
+	peakEWMA := &leastLoadConfig{Mode: LeastLoadModePeakEWMA}
+	serverLoad := &leastLoadConfig{Mode: LeastLoadModePeakEWMA, UseServerLoad: true}
+	ms := float64(time.Millisecond)
+
+	tests := []struct {
+		name  string
+		cfg   *leastLoadConfig
+		stats *loadStats
+		want  float64
+	}{
+		{"least request", leastRequest, &loadStats{weight: 1, outstanding: 3, ewma: 10 * ms}, 3},
+		{"least request weighted", leastRequest, &loadStats{weight: 3, outstanding: 3}, 1},
+		{"peak EWMA idle", peakEWMA, &loadStats{weight: 1, ewma: 10 * ms}, 10 * ms},
+		{"peak EWMA outstanding", peakEWMA, &loadStats{weight: 1, outstanding: 2, ewma: 10 * ms}, 30 * ms},
+		{"peak EWMA weighted", peakEWMA, &loadStats{weight: 2, outstanding: 1, ewma: 10 * ms}, 10 * ms},
+		// 还没有测得延迟的实例空闲时优先尝试，有在途请求时排在最后
+		{"unmeasured idle", peakEWMA, &loadStats{weight: 1}, 0},
+		{"unmeasured busy", peakEWMA, &loadStats{weight: 1, outstanding: 2}, ewmaPenalty + 2},
+		{"server load", serverLoad, &loadStats{weight: 1, ewma: 10 * ms, serverUtil: 0.5}, 15 * ms},
+		{"server load ignored", peakEWMA, &loadStats{weight: 1, ewma: 10 * ms, serverUtil: 0.5}, 10 * ms},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stats.cost(tt.cfg); got != tt.want {
+				t.Errorf("cost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// 只有两个实例时每次都比较两者，总是选中代价更小的一个
+func TestLeastLoadPickerPrefersLowerCost(t *testing.T) {
+	cfg := &leastLoadConfig{Mode: LeastLoadModePeakEWMA, decay: defaultEWMADecayTime}
+	fast := &loadStats{weight: 1, ewma: float64(time.Millisecond)}
+	slow := &loadStats{weight: 1, ewma: 19.5 * float64(time.Millisecond)}
+	p := &leastLoadPicker{
+		items: []*leastLoadItem{
+			{sc: testSubConn("fast"), addr: "fast", stats: fast},
+			{sc: testSubConn("slow"), addr: "slow", stats: slow},
+		},
+		config:   cfg,
+		detector: newOutlierDetector(),
+	}
+
+	// 在途请求使快实例的代价逐渐上升：有 n 个在途请求时为 (n+1)ms，超过慢实例的 19.5ms 后改选慢实例
+	for i := range 19 {
+		if addr := pickAddr(t, p); addr != "fast" {
+			t.Fatalf("pick %d: picked %s, want fast", i, addr)
+		}
+	}
+	if addr := pickAddr(t, p); addr != "slow" {
+		t.Errorf("picked %s, want slow once fast has 19 outstanding requests", addr)
+	}
+}
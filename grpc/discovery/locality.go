@@ -0,0 +1,200 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// 按地域优先级故障转移的负载均衡器名称
+const LocalityBalancerName = "locality_failover"
+
+// 默认健康阈值：某一优先级中 READY 的实例占比达到该值才只使用这一级
+const defaultMinHealthyPercent = 50
+
+// 实例所在位置，region 包含 zone
+type Locality struct {
+	Region string
+	Zone   string
+}
+
+// 挂在 resolver.Address.BalancerAttributes 上的位置 key
+type localityAttrKey struct{}
+
+func setAddrLocality(addr resolver.Address, loc Locality) resolver.Address {
+	addr.BalancerAttributes = addr.BalancerAttributes.WithValue(localityAttrKey{}, loc)
+	return addr
+}
+
+func getAddrLocality(addr resolver.Address) Locality {
+	loc, _ := addr.BalancerAttributes.Value(localityAttrKey{}).(Locality)
+	return loc
+}
+
+// 挂在 resolver.State.Attributes 上的客户端自身位置 key
+type clientLocalityAttrKey struct{}
+
+// 由 resolver 附带客户端自身的位置。位置因客户端而异，不能放进 etcd 中所有客户端共享的 service config
+func setStateLocality(state resolver.State, loc Locality) resolver.State {
+	state.Attributes = state.Attributes.WithValue(clientLocalityAttrKey{}, loc)
+	return state
+}
+
+func getStateLocality(state resolver.State) (Locality, bool) {
+	loc, ok := state.Attributes.Value(clientLocalityAttrKey{}).(Locality)
+	return loc, ok
+}
+
+// 从服务元数据中读取位置信息
+func localityFromMetadata(metadata map[string]string) Locality {
+	return Locality{Region: metadata["region"], Zone: metadata["zone"]}
+}
+
+// 优先级：同 zone > 同 region > 任意
+const (
+	prioritySameZone = iota
+	prioritySameRegion
+	priorityAny
+	priorityCount
+)
+
+// 负载均衡配置：{"loadBalancingConfig":[{"locality_failover":{"minHealthyPercent":50}}]}。
+// 客户端自身位置优先使用 resolver 随 State 下发的 Options.Locality；
+// 没有时才使用配置中的 region/zone，这种方式只适合客户端本地的默认配置
+type localityConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	Region            string `json:"region"`
+	Zone              string `json:"zone"`
+	MinHealthyPercent int    `json:"minHealthyPercent"`
+
+	OutlierDetection *outlierDetectionConfig `json:"outlierDetection"`
+
+	outlier *outlierConfig
+}
+
+// 实例 loc 相对客户端位置 home 的优先级
+func localityPriority(home, loc Locality) int {
+	switch {
+	case home.Region == "" || loc.Region != home.Region:
+		return priorityAny
+	case home.Zone != "" && loc.Zone == home.Zone:
+		return prioritySameZone
+	default:
+		return prioritySameRegion
+	}
+}
+
+func init() {
+	balancer.Register(&localityBalancerBuilder{})
+}
+
+type localityBalancerBuilder struct{}
+
+func (*localityBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &localityPickerBuilder{
+		config:   &localityConfig{MinHealthyPercent: defaultMinHealthyPercent},
+		detector: newOutlierDetector(),
+	}
+	return newBaseBalancer(LocalityBalancerName, cc, opts, pb, func(s balancer.ClientConnState) {
+		cfg, _ := s.BalancerConfig.(*localityConfig)
+		pb.update(s.ResolverState, cfg)
+	})
+}
+
+func (*localityBalancerBuilder) Name() string {
+	return LocalityBalancerName
+}
+
+func (*localityBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &localityConfig{MinHealthyPercent: defaultMinHealthyPercent}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse config: %v", LocalityBalancerName, err)
+	}
+	if cfg.MinHealthyPercent < 0 || cfg.MinHealthyPercent > 100 {
+		return nil, fmt.Errorf("%s: minHealthyPercent must be in [0, 100], got %d", LocalityBalancerName, cfg.MinHealthyPercent)
+	}
+
+	var err error
+	if cfg.outlier, err = cfg.OutlierDetection.parse(); err != nil {
+		return nil, fmt.Errorf("%s: %v", LocalityBalancerName, err)
+	}
+	return cfg, nil
+}
+
+type localityEndpoint struct {
+	priority int
+	weight   int
+}
+
+type localityPickerBuilder struct {
+	mu        sync.RWMutex
+	config    *localityConfig
+	endpoints map[string]localityEndpoint
+	// 每个优先级（含更高优先级）下的实例总数
+	totals   [priorityCount]int
+	detector *outlierDetector
+}
+
+func (pb *localityPickerBuilder) update(state resolver.State, cfg *localityConfig) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if cfg != nil {
+		pb.config = cfg
+	}
+	addrs := state.Addresses
+	pb.detector.update(addrStrings(addrs), pb.config.outlier)
+
+	home, ok := getStateLocality(state)
+	if !ok {
+		home = Locality{Region: pb.config.Region, Zone: pb.config.Zone}
+	}
+
+	pb.endpoints = make(map[string]localityEndpoint, len(addrs))
+	pb.totals = [priorityCount]int{}
+	for _, addr := range addrs {
+		ep := localityEndpoint{
+			priority: localityPriority(home, getAddrLocality(addr)),
+			weight:   getAddrWeight(addr),
+		}
+		pb.endpoints[addr.Addr] = ep
+		for p := ep.priority; p < priorityCount; p++ {
+			pb.totals[p]++
+		}
+	}
+}
+
+func (pb *localityPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	pb.mu.RLock()
+	defer pb.mu.RUnlock()
+
+	// 按优先级分组 READY 的 SubConn
+	var groups [priorityCount][]*weightedItem
+	for sc, scInfo := range info.ReadySCs {
+		ep, ok := pb.endpoints[scInfo.Address.Addr]
+		if !ok {
+			ep = localityEndpoint{priority: priorityAny, weight: 1}
+		}
+		groups[ep.priority] = append(groups[ep.priority], &weightedItem{sc: sc, addr: scInfo.Address.Addr, weight: ep.weight})
+	}
+
+	// 从最高优先级开始逐级放大范围，健康实例占比达到阈值就停止外溢
+	var items []*weightedItem
+	for p := 0; p < priorityCount; p++ {
+		items = append(items, groups[p]...)
+		if len(items) > 0 && len(items)*100 >= pb.totals[p]*pb.config.MinHealthyPercent {
+			break
+		}
+	}
+	return newWeightedPicker(items, pb.detector)
+}
@@ -0,0 +1,59 @@
+package discovery
+
+import (
+	"testing"
+
+	"google.golang.org/grpc/resolver"
+)
+
+func TestLocalityPriority(t *testing.T) {
+	addrs := []resolver.Address{
+		setAddrLocality(resolver.Address{Addr: "west-a"}, Locality{Region: "us-west", Zone: "a"}),
+		setAddrLocality(resolver.Address{Addr: "west-b"}, Locality{Region: "us-west", Zone: "b"}),
+		setAddrLocality(resolver.Address{Addr: "east-a"}, Locality{Region: "us-east", Zone: "a"}),
+	}
+	tests := []struct {
+		name  string
+		state resolver.State
+		cfg   *localityConfig
+		want  map[string]int
+	}{
+		{
+			name:  "client locality",
+			state: setStateLocality(resolver.State{Addresses: addrs}, Locality{Region: "us-east", Zone: "a"}),
+			want:  map[string]int{"west-a": priorityAny, "west-b": priorityAny, "east-a": prioritySameZone},
+		},
+		{
+			// 共享配置中的 region/zone 不能覆盖客户端自己的位置
+			name:  "client locality wins over config",
+			state: setStateLocality(resolver.State{Addresses: addrs}, Locality{Region: "us-east", Zone: "a"}),
+			cfg:   &localityConfig{Region: "us-west", Zone: "a", MinHealthyPercent: defaultMinHealthyPercent},
+			want:  map[string]int{"west-a": priorityAny, "west-b": priorityAny, "east-a": prioritySameZone},
+		},
+		{
+			name:  "config locality",
+			state: resolver.State{Addresses: addrs},
+			cfg:   &localityConfig{Region: "us-west", Zone: "a", MinHealthyPercent: defaultMinHealthyPercent},
+			want:  map[string]int{"west-a": prioritySameZone, "west-b": prioritySameRegion, "east-a": priorityAny},
+		},
+		{
+			name:  "no locality",
+			state: resolver.State{Addresses: addrs},
+			want:  map[string]int{"west-a": priorityAny, "west-b": priorityAny, "east-a": priorityAny},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pb := &localityPickerBuilder{
+				config:   &localityConfig{MinHealthyPercent: defaultMinHealthyPercent},
+				detector: newOutlierDetector(),
+			}
+			pb.update(tt.state, tt.cfg)
+			for addr, want := range tt.want {
+				if got := pb.endpoints[addr].priority; got != want {
+					t.Errorf("priority of %s = %d, want %d", addr, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+package discovery
+
+import "time"
+
+// Metrics 接收服务发现的监控数据，可以用 Prometheus 等实现。
+// 同一服务有多个 resolver 时 SetState 以最后一次下发为准
+type Metrics interface {
+	// 数据源推送了服务的最新状态（etcd watch 事件、文件变化、DNS 查询结果等）
+	ObserveUpdate(service string)
+	// 数据源出错，如 etcd 读取或 watch 失败
+	ObserveError(service string, err error)
+	// 下发给 ClientConn 的状态：数据源中注册的实例数、过滤后下发的地址数和最后一次成功同步的时间
+	SetState(service string, registered, selected int, syncedAt time.Time)
+}
+
+// 未配置 Metrics 时不记录
+type nopMetrics struct{}
+
+func (nopMetrics) ObserveUpdate(string)                 {}
+func (nopMetrics) ObserveError(string, error)           {}
+func (nopMetrics) SetState(string, int, int, time.Time) {}
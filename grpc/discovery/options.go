@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc/backoff"
+)
+
+const (
+	// 默认 resolver scheme
+	DefaultScheme = "custom-etcd"
+	// 默认服务 key 前缀
+	DefaultKeyPrefix = "/services/"
+)
+
+// 日志接口，*log.Logger 即满足
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// 实例过滤器，返回 false 的实例不会下发给 ClientConn
+type Filter func(info ServiceInfo) bool
+
+// 服务注册与发现的公共配置，零值字段使用默认值
+type Options struct {
+	// resolver scheme，如 custom-etcd:///hello-service
+	Scheme string
+	// 服务在 etcd 中的 key 前缀（命名空间），如 /env/prod/services/，
+	// 实例 key 为 <KeyPrefix><service>/<version>/<instance>，未指定版本时为 <KeyPrefix><service>/<instance>
+	KeyPrefix string
+	// 日志输出，默认使用标准库 log
+	Logger Logger
+	// 监控指标，默认不记录
+	Metrics Metrics
+	// 客户端自身所在位置，随解析结果下发给 locality_failover 负载均衡器，
+	// 优先于负载均衡配置中的 region/zone
+	Locality Locality
+	// 对所有目标生效的过滤器，先于目标地址中的元数据过滤条件执行
+	Filters []Filter
+	// 注册时写入 etcd 的格式，默认 FormatServiceInfo
+	Format Format
+	// 非空时把每个服务最后一次成功同步的实例列表保存到该目录，
+	// Build 时先加载快照，数据源不可用期间使用快照中的地址。
+	// 目录不存在时以 0700 创建；已存在但不属于当前用户或其他用户可写时不使用快照，见 DefaultSnapshotDir
+	SnapshotDir string
+	// 同步数据源失败后的重试退避，零值使用 backoff.DefaultConfig
+	Backoff backoff.Config
+}
+
+func (o Options) withDefaults() Options {
+	if o.Scheme == "" {
+		o.Scheme = DefaultScheme
+	}
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = DefaultKeyPrefix
+	}
+	if o.KeyPrefix[len(o.KeyPrefix)-1] != '/' {
+		o.KeyPrefix += "/"
+	}
+	if o.Logger == nil {
+		o.Logger = log.Default()
+	}
+	if o.Metrics == nil {
+		o.Metrics = nopMetrics{}
+	}
+	if o.Backoff == (backoff.Config{}) {
+		o.Backoff = backoff.DefaultConfig
+	}
+	return o
+}
+
+// 服务下所有实例的 key 前缀
+func (o Options) servicePrefix(serviceName string) string {
+	return fmt.Sprintf("%s%s/", o.KeyPrefix, serviceName)
+}
+
+// 单个实例的 key
+func (o Options) instanceKey(serviceName, version, instanceID string) string {
+	if version == "" {
+		return o.servicePrefix(serviceName) + instanceID
+	}
+	return o.servicePrefix(serviceName) + version + "/" + instanceID
+}
+
+// 从实例 key 中解析版本，不带版本的 key 返回空串
+func (o Options) keyVersion(serviceName, key string) string {
+	rel := strings.TrimPrefix(key, o.servicePrefix(serviceName))
+	if i := strings.LastIndex(rel, "/"); i >= 0 {
+		return rel[:i]
+	}
+	return ""
+}
+
+// 依次执行所有过滤器
+func (o Options) filter(infos []ServiceInfo) []ServiceInfo {
+	if len(o.Filters) == 0 {
+		return infos
+	}
+
+	var result []ServiceInfo
+	for _, info := range infos {
+		keep := true
+		for _, f := range o.Filters {
+			if !f(info) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			result = append(result, info)
+		}
+	}
+	return result
+}
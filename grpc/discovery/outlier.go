@@ -0,0 +1,229 @@
+package discovery
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
+)
+
+var logger = grpclog.Component("discovery")
+
+// 被动异常检测配置，作为负载均衡配置中的 outlierDetection 字段：
+//
+//	{"outlierDetection": {"interval": "10s", "baseEjectionTime": "30s", "consecutiveFailures": 5}}
+//
+// 未配置该字段时不做异常检测
+type outlierDetectionConfig struct {
+	// 成功率统计周期
+	Interval string `json:"interval"`
+	// 首次驱逐时长，之后每次驱逐按次数线性增长
+	BaseEjectionTime string `json:"baseEjectionTime"`
+	// 单次驱逐时长上限
+	MaxEjectionTime string `json:"maxEjectionTime"`
+	// 同时被驱逐的实例占比上限
+	MaxEjectionPercent int `json:"maxEjectionPercent"`
+	// 连续失败多少次立即驱逐，0 表示使用默认值
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// 一个周期内成功率低于该百分比则驱逐
+	MinSuccessRate int `json:"minSuccessRate"`
+	// 一个周期内请求数达到该值才计算成功率
+	MinRequests int `json:"minRequests"`
+}
+
+type outlierConfig struct {
+	interval            time.Duration
+	baseEjectionTime    time.Duration
+	maxEjectionTime     time.Duration
+	maxEjectionPercent  int
+	consecutiveFailures int
+	minSuccessRate      int
+	minRequests         int
+}
+
+func (c *outlierDetectionConfig) parse() (*outlierConfig, error) {
+	if c == nil {
+		return nil, nil
+	}
+
+	cfg := &outlierConfig{
+		interval:            10 * time.Second,
+		baseEjectionTime:    30 * time.Second,
+		maxEjectionTime:     300 * time.Second,
+		maxEjectionPercent:  50,
+		consecutiveFailures: 5,
+		minSuccessRate:      80,
+		minRequests:         20,
+	}
+
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"interval", c.Interval, &cfg.interval},
+		{"baseEjectionTime", c.BaseEjectionTime, &cfg.baseEjectionTime},
+		{"maxEjectionTime", c.MaxEjectionTime, &cfg.maxEjectionTime},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("outlierDetection: invalid %s %q", d.name, d.value)
+		}
+		*d.dst = v
+	}
+
+	if c.MaxEjectionPercent < 0 || c.MaxEjectionPercent > 100 {
+		return nil, fmt.Errorf("outlierDetection: maxEjectionPercent must be in [0, 100], got %d", c.MaxEjectionPercent)
+	}
+	if c.MinSuccessRate < 0 || c.MinSuccessRate > 100 {
+		return nil, fmt.Errorf("outlierDetection: minSuccessRate must be in [0, 100], got %d", c.MinSuccessRate)
+	}
+	if c.MaxEjectionPercent > 0 {
+		cfg.maxEjectionPercent = c.MaxEjectionPercent
+	}
+	if c.ConsecutiveFailures > 0 {
+		cfg.consecutiveFailures = c.ConsecutiveFailures
+	}
+	if c.MinSuccessRate > 0 {
+		cfg.minSuccessRate = c.MinSuccessRate
+	}
+	if c.MinRequests > 0 {
+		cfg.minRequests = c.MinRequests
+	}
+	cfg.maxEjectionTime = max(cfg.maxEjectionTime, cfg.baseEjectionTime)
+	return cfg, nil
+}
+
+type hostStats struct {
+	success             int
+	failure             int
+	consecutiveFailures int
+	ejections           int
+	ejectedUntil        time.Time
+}
+
+// 根据 RPC 结果被动统计每个地址的成功率和连续失败次数，驱逐异常实例一段时间
+type outlierDetector struct {
+	mu       sync.Mutex
+	cfg      *outlierConfig
+	hosts    map[string]*hostStats
+	lastEval time.Time
+}
+
+func newOutlierDetector() *outlierDetector {
+	return &outlierDetector{hosts: make(map[string]*hostStats)}
+}
+
+// 同步地址列表和配置，已有地址的统计数据保留
+func (d *outlierDetector) update(addrs []string, cfg *outlierConfig) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.cfg = cfg
+	hosts := make(map[string]*hostStats, len(addrs))
+	for _, addr := range addrs {
+		h, ok := d.hosts[addr]
+		if !ok {
+			h = &hostStats{}
+		}
+		hosts[addr] = h
+	}
+	d.hosts = hosts
+	if d.lastEval.IsZero() {
+		d.lastEval = time.Now()
+	}
+}
+
+func (d *outlierDetector) ejected(addr string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cfg == nil {
+		return false
+	}
+	h, ok := d.hosts[addr]
+	return ok && time.Now().Before(h.ejectedUntil)
+}
+
+// 这些状态码说明后端本身有问题，其余错误（参数错误、权限等）不计入失败
+func isBackendFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DeadlineExceeded, codes.DataLoss:
+		return true
+	default:
+		return false
+	}
+}
+
+// 记录一次 RPC 结果
+func (d *outlierDetector) record(addr string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.cfg == nil {
+		return
+	}
+	h, ok := d.hosts[addr]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if isBackendFailure(err) {
+		h.failure++
+		h.consecutiveFailures++
+		if h.consecutiveFailures >= d.cfg.consecutiveFailures {
+			d.eject(addr, h, now, fmt.Sprintf("%d consecutive failures", h.consecutiveFailures))
+		}
+	} else {
+		h.success++
+		h.consecutiveFailures = 0
+	}
+
+	if now.Sub(d.lastEval) >= d.cfg.interval {
+		d.evaluate(now)
+	}
+}
+
+// 周期结束时按成功率驱逐，并让长期正常的实例逐渐恢复驱逐次数
+func (d *outlierDetector) evaluate(now time.Time) {
+	d.lastEval = now
+	for addr, h := range d.hosts {
+		total := h.success + h.failure
+		if total >= d.cfg.minRequests && h.success*100 < total*d.cfg.minSuccessRate {
+			d.eject(addr, h, now, fmt.Sprintf("success rate %d/%d", h.success, total))
+		} else if h.ejections > 0 && !now.Before(h.ejectedUntil) {
+			h.ejections--
+		}
+		h.success, h.failure = 0, 0
+	}
+}
+
+func (d *outlierDetector) eject(addr string, h *hostStats, now time.Time, reason string) {
+	if now.Before(h.ejectedUntil) {
+		return
+	}
+
+	ejected := 0
+	for _, other := range d.hosts {
+		if now.Before(other.ejectedUntil) {
+			ejected++
+		}
+	}
+	if (ejected+1)*100 > len(d.hosts)*d.cfg.maxEjectionPercent {
+		logger.Warningf("Not ejecting %s (%s): max ejection percent %d%% reached", addr, reason, d.cfg.maxEjectionPercent)
+		return
+	}
+
+	h.ejections++
+	h.consecutiveFailures = 0
+	duration := min(d.cfg.baseEjectionTime*time.Duration(h.ejections), d.cfg.maxEjectionTime)
+	h.ejectedUntil = now.Add(duration)
+	logger.Warningf("Ejected %s for %v: %s", addr, duration, reason)
+}
@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"fmt"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestOutlierEjectionCap(t *testing.T) {
+	tests := []struct {
+		name               string
+		hosts              int
+		maxEjectionPercent int
+		want               int
+	}{
+		{"default half", 10, 0, 5},
+		{"percent", 10, 30, 3},
+		{"rounds down", 3, 50, 1},
+		// 比例不足以驱逐一个实例时不驱逐
+		{"below one host", 3, 20, 0},
+		{"no cap", 4, 100, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := (&outlierDetectionConfig{ConsecutiveFailures: 2, MaxEjectionPercent: tt.maxEjectionPercent}).parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var addrs []string
+			for i := range tt.hosts {
+				addrs = append(addrs, fmt.Sprintf("10.0.0.%d:80", i+1))
+			}
+			d := newOutlierDetector()
+			d.update(addrs, cfg)
+
+			// 所有实例都连续失败，被驱逐的数量受比例上限约束
+			for range 2 {
+				for _, addr := range addrs {
+					d.record(addr, status.Error(codes.Unavailable, "unavailable"))
+				}
+			}
+			ejected := 0
+			for _, addr := range addrs {
+				if d.ejected(addr) {
+					ejected++
+				}
+			}
+			if ejected != tt.want {
+				t.Errorf("%d of %d instances ejected, want %d", ejected, tt.hosts, tt.want)
+			}
+		})
+	}
+}
+
+// 调用方自身的错误（参数错误、权限等）不说明后端异常，不计入失败
+func TestOutlierIgnoresClientErrors(t *testing.T) {
+	cfg, err := (&outlierDetectionConfig{ConsecutiveFailures: 1, MaxEjectionPercent: 100}).parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newOutlierDetector()
+	d.update([]string{"10.0.0.1:80"}, cfg)
+	for range 5 {
+		d.record("10.0.0.1:80", status.Error(codes.InvalidArgument, "bad request"))
+	}
+	if d.ejected("10.0.0.1:80") {
+		t.Error("instance ejected after client errors")
+	}
+}
@@ -0,0 +1,190 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// 默认租约 TTL
+	DefaultTTL = 10 * time.Second
+
+	// 租约丢失后重新注册的退避上限
+	maxRetryBackoff = 30 * time.Second
+)
+
+// 基于 etcd 租约的服务注册器：
+// 注册时申请租约并后台续约，租约丢失或 etcd 重连后自动重新注册，
+// 关闭时撤销租约，key 随之删除
+type Registrar struct {
+	etcdClient *clientv3.Client
+	key        string
+	value      string
+	ttl        time.Duration
+	logger     Logger
+
+	mu      sync.Mutex
+	leaseID clientv3.LeaseID
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewRegistrar(etcdClient *clientv3.Client, serviceName, instanceID string, info ServiceInfo, ttl time.Duration, opts Options) (*Registrar, error) {
+	opts = opts.withDefaults()
+
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if ttl < time.Second {
+		return nil, fmt.Errorf("lease ttl must be at least 1s, got %v", ttl)
+	}
+
+	data, err := marshalServiceInfo(info, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Registrar{
+		etcdClient: etcdClient,
+		key:        opts.instanceKey(serviceName, info.Version, instanceID),
+		value:      string(data),
+		ttl:        ttl,
+		logger:     opts.Logger,
+	}, nil
+}
+
+// Register 完成首次注册并启动后台续约，只能调用一次
+func (r *Registrar) Register(ctx context.Context) error {
+	r.mu.Lock()
+	if r.done != nil {
+		r.mu.Unlock()
+		return errors.New("registrar already started")
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	r.done = make(chan struct{})
+	r.mu.Unlock()
+
+	keepAlive, err := r.register(ctx)
+	if err != nil {
+		r.cancel()
+		close(r.done)
+		return err
+	}
+
+	go r.run(keepAlive)
+	return nil
+}
+
+// 申请租约并写入 key，返回续约通道
+func (r *Registrar) register(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	lease, err := r.etcdClient.Grant(ctx, int64(r.ttl/time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to grant lease: %v", err)
+	}
+
+	if _, err := r.etcdClient.Put(ctx, r.key, r.value, clientv3.WithLease(lease.ID)); err != nil {
+		return nil, fmt.Errorf("failed to register service: %v", err)
+	}
+
+	// 续约使用注册器自身的 ctx，不受调用方 ctx 影响
+	keepAlive, err := r.etcdClient.KeepAlive(r.ctx, lease.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to keep lease alive: %v", err)
+	}
+
+	r.mu.Lock()
+	r.leaseID = lease.ID
+	r.mu.Unlock()
+
+	r.logger.Printf("Registered service: %s (lease %x, ttl %v)", r.key, lease.ID, r.ttl)
+	return keepAlive, nil
+}
+
+func (r *Registrar) run(keepAlive <-chan *clientv3.LeaseKeepAliveResponse) {
+	defer close(r.done)
+
+	for {
+		// 消费续约响应，通道关闭说明租约已丢失或 etcd 连接中断
+		for range keepAlive {
+		}
+
+		if r.ctx.Err() != nil {
+			return
+		}
+		r.logger.Printf("Lease for %s lost, re-registering...", r.key)
+
+		backoff := time.Second
+		for {
+			var err error
+			keepAlive, err = r.register(r.ctx)
+			if err == nil {
+				break
+			}
+			r.logger.Printf("Re-register %s failed: %v, retry in %v", r.key, err, backoff)
+
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, maxRetryBackoff)
+		}
+	}
+}
+
+// Close 停止续约并撤销租约
+func (r *Registrar) Close() error {
+	r.mu.Lock()
+	if r.done == nil {
+		r.mu.Unlock()
+		return nil
+	}
+	r.cancel()
+	r.mu.Unlock()
+
+	<-r.done
+
+	r.mu.Lock()
+	leaseID := r.leaseID
+	r.leaseID = clientv3.NoLease
+	r.mu.Unlock()
+
+	if leaseID == clientv3.NoLease {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := r.etcdClient.Revoke(ctx, leaseID); err != nil {
+		return fmt.Errorf("failed to revoke lease: %v", err)
+	}
+
+	r.logger.Printf("Deregistered service: %s", r.key)
+	return nil
+}
+
+// 辅助函数：不带租约地注册服务到 etcd，适合静态实例；
+// 需要随进程上下线的实例应使用 Registrar
+func RegisterService(ctx context.Context, etcdClient *clientv3.Client, serviceName, instanceID string, info ServiceInfo, opts Options) error {
+	opts = opts.withDefaults()
+	key := opts.instanceKey(serviceName, info.Version, instanceID)
+
+	data, err := marshalServiceInfo(info, opts.Format)
+	if err != nil {
+		return err
+	}
+
+	_, err = etcdClient.Put(ctx, key, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to register service: %v", err)
+	}
+
+	opts.Logger.Printf("Registered service: %s -> %s", key, info.Addr)
+	return nil
+}
@@ -0,0 +1,266 @@
+package discovery
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+)
+
+// 自定义 resolver 构建器，通过 resolver.Register 或 grpc.WithResolvers 使用
+type Builder struct {
+	backend Backend
+	opts    Options
+
+	mu        sync.Mutex
+	resolvers map[*serviceResolver]struct{}
+}
+
+// NewBuilder 创建以 etcd 为数据源的构建器
+func NewBuilder(etcdClient *clientv3.Client, opts Options) *Builder {
+	return NewBackendBuilder(NewEtcdBackend(etcdClient, opts), opts)
+}
+
+// NewBackendBuilder 创建使用任意数据源的构建器，不同数据源通常使用不同的 Options.Scheme
+func NewBackendBuilder(backend Backend, opts Options) *Builder {
+	return &Builder{
+		backend:   backend,
+		opts:      opts.withDefaults(),
+		resolvers: make(map[*serviceResolver]struct{}),
+	}
+}
+
+func (b *Builder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	// 解析目标地址中的元数据过滤条件
+	filter, err := parseMetadataFilter(target.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+
+	// 解析目标地址中的版本选择条件
+	version, err := parseVersionSelector(target.URL.Query().Get(versionParam))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// 创建自定义 resolver
+	r := &serviceResolver{
+		target:       target,
+		cc:           cc,
+		ctx:          ctx,
+		cancel:       cancel,
+		opts:         b.opts,
+		addressCache: make(map[string]ServiceInfo),
+		filter:       filter,
+		version:      version,
+		builder:      b,
+	}
+	if b.opts.SnapshotDir != "" {
+		// 目录不安全时不读写快照，只依赖数据源
+		if err := prepareSnapshotDir(b.opts.SnapshotDir); err != nil {
+			b.opts.Logger.Printf("Snapshots disabled: %v", err)
+		} else {
+			r.snapshotPath = snapshotPath(b.opts.SnapshotDir, b.opts.Scheme, b.opts.KeyPrefix, target.Endpoint())
+		}
+	}
+
+	b.mu.Lock()
+	b.resolvers[r] = struct{}{}
+	b.mu.Unlock()
+
+	// 启动监听
+	go r.start()
+	return r, nil
+}
+
+func (b *Builder) Scheme() string {
+	return b.opts.Scheme
+}
+
+// Staleness 返回服务当前使用的实例列表已过期多久：
+// 与数据源保持同步时为 0，数据源不可用而使用快照或旧缓存时为距最后一次成功同步的时间；
+// 服务没有对应的 resolver 或尚无任何数据时 ok 为 false
+func (b *Builder) Staleness(serviceName string) (age time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for r := range b.resolvers {
+		if r.target.Endpoint() != serviceName {
+			continue
+		}
+		if a, rok := r.staleness(); rok {
+			age, ok = max(age, a), true
+		}
+	}
+	return age, ok
+}
+
+// 自定义 resolver：从数据源接收服务状态，过滤后下发给 ClientConn
+type serviceResolver struct {
+	target resolver.Target
+	cc     resolver.ClientConn
+	ctx    context.Context
+	cancel context.CancelFunc
+	opts   Options
+
+	// 串行化 updateState：读取缓存、构建状态和下发给 ClientConn 在同一临界区内完成，
+	// ResolveNow 和数据源推送并发时，先构建的旧状态不会在新状态之后下发
+	updateMu sync.Mutex
+
+	mu           sync.RWMutex
+	addressCache map[string]ServiceInfo
+	// 数据源中的 service config 原始 JSON
+	serviceConfig string
+
+	filter  *metadataFilter
+	version *versionSelector
+
+	builder      *Builder
+	snapshotPath string
+	// 最后一次与数据源成功同步的时间，stale 表示当前缓存可能已落后于数据源
+	syncedAt time.Time
+	stale    bool
+}
+
+func (r *serviceResolver) start() {
+	// 先用本地快照提供地址，数据源不可用时也能发起调用
+	if r.loadSnapshot() {
+		r.updateState()
+	}
+
+	r.builder.backend.Watch(r.ctx, r.target.Endpoint(), r.onUpdate)
+}
+
+// 处理数据源推送的服务状态
+func (r *serviceResolver) onUpdate(u Update) {
+	if r.ctx.Err() != nil {
+		return
+	}
+
+	if u.Err != nil {
+		// 保留已有数据继续服务，同时把错误报告给 ClientConn
+		r.opts.Metrics.ObserveError(r.target.Endpoint(), u.Err)
+		r.markStale()
+		r.cc.ReportError(status.Errorf(codes.Unavailable, "failed to resolve service %q: %v", r.target.Endpoint(), u.Err))
+		return
+	}
+
+	r.mu.Lock()
+	r.addressCache = u.Instances
+	if r.addressCache == nil {
+		r.addressCache = make(map[string]ServiceInfo)
+	}
+	r.serviceConfig = u.ServiceConfig
+	r.syncedAt = time.Now()
+	r.stale = false
+	r.mu.Unlock()
+
+	r.opts.Metrics.ObserveUpdate(r.target.Endpoint())
+	r.updateState()
+	r.saveSnapshot()
+}
+
+// 缓存由数据源主动推送维护，这里直接重新下发当前缓存
+func (r *serviceResolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.updateState()
+}
+
+func (r *serviceResolver) updateState() {
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+
+	// 所有字段在同一次加锁中读取，保证来自同一份缓存
+	r.mu.RLock()
+	syncedAt := r.syncedAt
+	registered := len(r.addressCache)
+	serviceConfig := r.serviceConfig
+	// 返回所有可用地址，权重随地址下发，由负载均衡器按权重选择
+	addrs := r.selectAll()
+	r.mu.RUnlock()
+
+	// 还没有从数据源或快照拿到任何数据，不能下发空列表
+	if syncedAt.IsZero() {
+		return
+	}
+
+	state := resolver.State{Addresses: addrs}
+	if r.opts.Locality != (Locality{}) {
+		state = setStateLocality(state, r.opts.Locality)
+	}
+	r.opts.Metrics.SetState(r.target.Endpoint(), registered, len(addrs), syncedAt)
+
+	// 数据源中没有 service config 时保持为 nil，ClientConn 使用 WithDefaultServiceConfig 的配置；
+	// 配置非法时 ParseResult 带有错误，ClientConn 会继续使用上一份合法配置
+	if serviceConfig != "" {
+		state.ServiceConfig = r.cc.ParseServiceConfig(serviceConfig)
+		if state.ServiceConfig.Err != nil {
+			r.opts.Logger.Printf("Invalid service config for %s: %v", r.target.Endpoint(), state.ServiceConfig.Err)
+		}
+	}
+
+	if len(addrs) > 0 {
+		r.opts.Logger.Printf("Selected addresses: %v", r.formatAddresses(addrs))
+	} else {
+		// 空列表附带明确的原因，本包的负载均衡器会用它让 RPC 立即失败
+		err := noInstancesError(r.target.Endpoint(), registered)
+		state = setStateError(state, err)
+		r.opts.Logger.Printf("No addresses available: %v", err)
+	}
+
+	if err := r.cc.UpdateState(state); err != nil && len(addrs) > 0 {
+		r.opts.Logger.Printf("ClientConn rejected resolver state: %v", err)
+	}
+}
+
+// 选择所有满足版本和元数据过滤条件的地址，并把权重和位置附加到地址属性上，调用方需持有 r.mu
+func (r *serviceResolver) selectAll() []resolver.Address {
+	infos := make([]ServiceInfo, 0, len(r.addressCache))
+	for _, info := range r.addressCache {
+		if r.version.matches(info.Version) {
+			infos = append(infos, info)
+		}
+	}
+
+	var addrs []resolver.Address
+	for _, info := range r.filter.apply(r.opts.filter(infos)) {
+		addr := setAddrWeight(resolver.Address{Addr: info.Addr}, info.Weight)
+		addrs = append(addrs, setAddrLocality(addr, localityFromMetadata(info.Metadata)))
+	}
+
+	return addrs
+}
+
+// 获取缓存的服务信息（供选择策略使用）
+func (r *serviceResolver) getServiceInfos() map[string]ServiceInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// 返回副本以避免并发问题
+	result := make(map[string]ServiceInfo)
+	for k, v := range r.addressCache {
+		result[k] = v
+	}
+	return result
+}
+
+func (r *serviceResolver) formatAddresses(addrs []resolver.Address) []string {
+	var result []string
+	for _, addr := range addrs {
+		result = append(result, addr.Addr)
+	}
+	return result
+}
+
+func (r *serviceResolver) Close() {
+	r.cancel()
+
+	r.builder.mu.Lock()
+	delete(r.builder.resolvers, r)
+	r.builder.mu.Unlock()
+}
@@ -0,0 +1,148 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// 记录 resolver 下发状态的 ClientConn
+type recordingClientConn struct {
+	resolver.ClientConn
+
+	mu    sync.Mutex
+	state resolver.State
+}
+
+func (cc *recordingClientConn) UpdateState(s resolver.State) error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.state = s
+	return nil
+}
+
+func (cc *recordingClientConn) ReportError(error) {}
+
+func (cc *recordingClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func newTestResolver(t *testing.T, cc resolver.ClientConn) *serviceResolver {
+	t.Helper()
+	filter, err := parseMetadataFilter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return &serviceResolver{
+		target:       resolver.Target{},
+		cc:           cc,
+		ctx:          ctx,
+		cancel:       cancel,
+		opts:         Options{Logger: log.New(io.Discard, "", 0)}.withDefaults(),
+		addressCache: make(map[string]ServiceInfo),
+		filter:       filter,
+	}
+}
+
+func instances(n int) map[string]ServiceInfo {
+	m := make(map[string]ServiceInfo, n)
+	for i := range n {
+		addr := fmt.Sprintf("10.0.0.%d:80", i+1)
+		m[addr] = ServiceInfo{Addr: addr, Weight: 1}
+	}
+	return m
+}
+
+// ResolveNow 与数据源推送并发时，最后下发的状态必须是最新的缓存
+func TestUpdateStateOrdering(t *testing.T) {
+	const updates = 200
+	for range 20 {
+		cc := &recordingClientConn{}
+		r := newTestResolver(t, cc)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 1; i <= updates; i++ {
+				r.onUpdate(Update{Instances: instances(i)})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range updates {
+				r.ResolveNow(resolver.ResolveNowOptions{})
+			}
+		}()
+		wg.Wait()
+
+		if n := len(cc.state.Addresses); n != updates {
+			t.Fatalf("last state has %d addresses, want %d", n, updates)
+		}
+	}
+}
+
+func TestSnapshotPathKeyPrefix(t *testing.T) {
+	def := snapshotPath("/cache", DefaultScheme, DefaultKeyPrefix, "hello-service")
+	if want := "/cache/custom-etcd_hello-service.json"; def != want {
+		t.Errorf("default prefix snapshot = %s, want %s", def, want)
+	}
+	prod := snapshotPath("/cache", DefaultScheme, "/env/prod/services/", "hello-service")
+	staging := snapshotPath("/cache", DefaultScheme, "/env/staging/services/", "hello-service")
+	if prod == def || prod == staging {
+		t.Errorf("namespaces share a snapshot: default %s, prod %s, staging %s", def, prod, staging)
+	}
+}
+
+func TestPrepareSnapshotDir(t *testing.T) {
+	base := t.TempDir()
+
+	dir := filepath.Join(base, "new", "snapshots")
+	if err := prepareSnapshotDir(dir); err != nil {
+		t.Fatalf("new dir: %v", err)
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o700 {
+		t.Errorf("new dir mode = %v, want 0700", perm)
+	}
+
+	shared := filepath.Join(base, "shared")
+	if err := os.Mkdir(shared, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(shared, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareSnapshotDir(shared); err == nil {
+		t.Error("world-writable dir accepted")
+	}
+
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareSnapshotDir(file); err == nil {
+		t.Error("regular file accepted")
+	}
+
+	link := filepath.Join(base, "link")
+	if err := os.Symlink(dir, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareSnapshotDir(link); err == nil {
+		t.Error("symlink accepted")
+	}
+}
@@ -0,0 +1,234 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// 一致性哈希负载均衡器名称
+const ConsistentHashBalancerName = "consistent_hash"
+
+// 每单位权重的虚拟节点数，越多负载越均匀，但构建越慢
+const (
+	defaultVirtualNodes = 160
+	maxVirtualNodes     = 4096
+)
+
+type hashKeyCtxKey struct{}
+
+// WithHashKey 指定本次请求的哈希 key，优先于 hashHeader 配置的请求头
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyCtxKey{}, key)
+}
+
+// 负载均衡配置，按请求头 x-user-id 做粘性路由：
+// {"loadBalancingConfig":[{"consistent_hash":{"hashHeader":"x-user-id","virtualNodes":160}}]}
+type consistentHashConfig struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	HashHeader string `json:"hashHeader"`
+	// 每单位权重的虚拟节点数，与实例总数无关，实例增减时其余实例的节点保持不变
+	VirtualNodes int `json:"virtualNodes"`
+
+	OutlierDetection *outlierDetectionConfig `json:"outlierDetection"`
+
+	outlier *outlierConfig
+}
+
+func init() {
+	balancer.Register(&consistentHashBalancerBuilder{})
+}
+
+type consistentHashBalancerBuilder struct{}
+
+func (*consistentHashBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	pb := &consistentHashPickerBuilder{
+		config:   &consistentHashConfig{VirtualNodes: defaultVirtualNodes},
+		detector: newOutlierDetector(),
+	}
+	return newBaseBalancer(ConsistentHashBalancerName, cc, opts, pb, func(s balancer.ClientConnState) {
+		cfg, _ := s.BalancerConfig.(*consistentHashConfig)
+		pb.update(s.ResolverState.Addresses, cfg)
+	})
+}
+
+func (*consistentHashBalancerBuilder) Name() string {
+	return ConsistentHashBalancerName
+}
+
+func (*consistentHashBalancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	cfg := &consistentHashConfig{VirtualNodes: defaultVirtualNodes}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("%s: failed to parse config: %v", ConsistentHashBalancerName, err)
+	}
+	if cfg.VirtualNodes <= 0 || cfg.VirtualNodes > maxVirtualNodes {
+		return nil, fmt.Errorf("%s: virtualNodes must be in [1, %d], got %d", ConsistentHashBalancerName, maxVirtualNodes, cfg.VirtualNodes)
+	}
+	// gRPC metadata 的 key 都是小写
+	cfg.HashHeader = strings.ToLower(cfg.HashHeader)
+
+	var err error
+	if cfg.outlier, err = cfg.OutlierDetection.parse(); err != nil {
+		return nil, fmt.Errorf("%s: %v", ConsistentHashBalancerName, err)
+	}
+	return cfg, nil
+}
+
+// 哈希环上的一个虚拟节点
+type ringEntry struct {
+	hash uint64
+	addr string
+}
+
+type consistentHashPickerBuilder struct {
+	mu       sync.RWMutex
+	config   *consistentHashConfig
+	ring     []ringEntry
+	detector *outlierDetector
+}
+
+// 用 resolver 给出的全部地址构建哈希环，而不只是 READY 的地址，
+// 这样实例短暂不可用时只有落在它上面的 key 会临时顺延到下一个节点
+func (pb *consistentHashPickerBuilder) update(addrs []resolver.Address, cfg *consistentHashConfig) {
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+
+	if cfg != nil {
+		pb.config = cfg
+	}
+	pb.detector.update(addrStrings(addrs), pb.config.outlier)
+	pb.ring = newRing(addrs, pb.config.VirtualNodes)
+}
+
+func (pb *consistentHashPickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	pb.mu.RLock()
+	defer pb.mu.RUnlock()
+
+	ready := make(map[string]balancer.SubConn, len(info.ReadySCs))
+	for sc, scInfo := range info.ReadySCs {
+		ready[scInfo.Address.Addr] = sc
+	}
+	return &consistentHashPicker{
+		ring:       pb.ring,
+		ready:      ready,
+		hashHeader: pb.config.HashHeader,
+		detector:   pb.detector,
+	}
+}
+
+// 按权重分配虚拟节点：每单位权重固定 virtualNodes 个节点。
+// 节点只取决于地址和它自己的权重，实例增减时其余节点不变，只有增减的实例所在区间的 key 会迁移
+func newRing(addrs []resolver.Address, virtualNodes int) []ringEntry {
+	if len(addrs) == 0 {
+		return nil
+	}
+
+	total := 0
+	for _, addr := range addrs {
+		total += getAddrWeight(addr)
+	}
+
+	ring := make([]ringEntry, 0, total*virtualNodes)
+	for _, addr := range addrs {
+		n := getAddrWeight(addr) * virtualNodes
+		for i := 0; i < n; i++ {
+			ring = append(ring, ringEntry{hash: hashString(addr.Addr + "_" + strconv.Itoa(i)), addr: addr.Addr})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+// FNV-1a 加 splitmix64 收尾打散。不同客户端必须得到相同的结果，不能用带随机种子的哈希
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// 从 key 的哈希位置顺时针找第一个 READY 且未被驱逐的节点；
+// 全部被驱逐时忽略驱逐状态。请求没有 key 时随机选一个位置
+type consistentHashPicker struct {
+	ring       []ringEntry
+	ready      map[string]balancer.SubConn
+	hashHeader string
+	detector   *outlierDetector
+}
+
+func (p *consistentHashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	var h uint64
+	if key, ok := p.hashKey(info.Ctx); ok {
+		h = hashString(key)
+	} else {
+		h = rand.Uint64()
+	}
+
+	addr, sc := p.lookup(h)
+	if sc == nil {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+	return balancer.PickResult{
+		SubConn: sc,
+		Done: func(info balancer.DoneInfo) {
+			p.detector.record(addr, info.Err)
+		},
+	}, nil
+}
+
+func (p *consistentHashPicker) hashKey(ctx context.Context) (string, bool) {
+	if key, ok := ctx.Value(hashKeyCtxKey{}).(string); ok {
+		return key, true
+	}
+	if p.hashHeader == "" {
+		return "", false
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if values := md.Get(p.hashHeader); len(values) > 0 {
+		return values[0], true
+	}
+	return "", false
+}
+
+func (p *consistentHashPicker) lookup(h uint64) (string, balancer.SubConn) {
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+
+	var fallbackAddr string
+	var fallback balancer.SubConn
+	for i := 0; i < len(p.ring); i++ {
+		entry := p.ring[(start+i)%len(p.ring)]
+		sc, ok := p.ready[entry.addr]
+		if !ok {
+			continue
+		}
+		if !p.detector.ejected(entry.addr) {
+			return entry.addr, sc
+		}
+		if fallback == nil {
+			fallbackAddr, fallback = entry.addr, sc
+		}
+	}
+	return fallbackAddr, fallback
+}
@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"strconv"
+	"testing"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// 用给定地址建环，返回每个 key 落到的地址
+func ringAssignments(addrs []string, keys int) map[string]string {
+	var resolved []resolver.Address
+	ready := make(map[string]balancer.SubConn, len(addrs))
+	for _, addr := range addrs {
+		resolved = append(resolved, resolver.Address{Addr: addr})
+		ready[addr] = testSubConn(addr)
+	}
+	p := &consistentHashPicker{
+		ring:     newRing(resolved, defaultVirtualNodes),
+		ready:    ready,
+		detector: newOutlierDetector(),
+	}
+
+	result := make(map[string]string, keys)
+	for i := range keys {
+		key := "user-" + strconv.Itoa(i)
+		addr, _ := p.lookup(hashString(key))
+		result[key] = addr
+	}
+	return result
+}
+
+func testAddrs(n int) []string {
+	result := make([]string, 0, n)
+	for i := range n {
+		result = append(result, "10.0.0."+strconv.Itoa(i+1)+":80")
+	}
+	return result
+}
+
+// 实例增减时只有增减的实例上的 key 迁移，其余实例之间不发生迁移
+func TestRingRemapping(t *testing.T) {
+	const keys = 10000
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+	}{
+		{"join 5 to 6", testAddrs(5), testAddrs(6)},
+		{"join 1 to 2", testAddrs(1), testAddrs(2)},
+		{"leave 5 to 4", testAddrs(5), testAddrs(4)},
+		{"leave 4 to 3", testAddrs(4), testAddrs(3)},
+		{"leave 3 to 2", testAddrs(3), testAddrs(2)},
+		{"leave from the middle", testAddrs(4), []string{"10.0.0.1:80", "10.0.0.3:80", "10.0.0.4:80"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := ringAssignments(tt.before, keys)
+			after := ringAssignments(tt.after, keys)
+			stayed := make(map[string]bool)
+			for _, addr := range tt.after {
+				stayed[addr] = true
+			}
+			joined := make(map[string]bool)
+			for _, addr := range tt.after {
+				joined[addr] = true
+			}
+			for _, addr := range tt.before {
+				delete(joined, addr)
+			}
+
+			moved := 0
+			for key, addr := range after {
+				if addr == before[key] {
+					continue
+				}
+				moved++
+				// 加入时 key 只能迁到新实例，离开时只有原本落在离开的实例上的 key 会迁移
+				if !joined[addr] && stayed[before[key]] {
+					t.Fatalf("key %s moved from %s to %s", key, before[key], addr)
+				}
+			}
+
+			// 理想迁移比例为 1/max(增减前后的实例数)，允许一定偏差
+			n := max(len(tt.before), len(tt.after))
+			if want := keys / n; moved < want/2 || moved > want*3/2 {
+				t.Errorf("%d of %d keys moved, want about %d", moved, keys, want)
+			}
+		})
+	}
+}
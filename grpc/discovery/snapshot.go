@@ -0,0 +1,172 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 本地快照：保存最后一次从数据源成功同步的实例列表，
+// Build 时先加载快照，数据源（如 etcd）不可用时客户端仍有地址可用
+type snapshot struct {
+	Service   string                 `json:"service"`
+	UpdatedAt time.Time              `json:"updatedAt"`
+	Instances map[string]ServiceInfo `json:"instances"`
+	Config    string                 `json:"config,omitempty"`
+}
+
+// DefaultSnapshotDir 返回当前用户缓存目录下的快照目录，如 ~/.cache/grpc-discovery。
+// 不要使用 os.TempDir() 这类所有用户可写的目录：其他用户可以抢先创建同名目录并写入伪造的实例列表
+func DefaultSnapshotDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grpc-discovery"), nil
+}
+
+// 创建快照目录，目录已存在时确认它属于当前用户且其他用户不可写，
+// 否则快照内容可能被篡改，不能使用
+func prepareSnapshotDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !fi.IsDir():
+		return fmt.Errorf("snapshot dir %s is not a directory", dir)
+	case !ownedByCurrentUser(fi):
+		return fmt.Errorf("snapshot dir %s is not owned by the current user", dir)
+	case fi.Mode().Perm()&0o022 != 0:
+		return fmt.Errorf("snapshot dir %s is writable by other users (mode %v)", dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// 不同 key 前缀（命名空间）下的同名服务是不同的服务，使用非默认前缀时文件名带上前缀，
+// 切换命名空间后不会加载另一个命名空间的快照
+func snapshotPath(dir, scheme, keyPrefix, serviceName string) string {
+	name := serviceName
+	if keyPrefix != DefaultKeyPrefix {
+		name = keyPrefix + serviceName
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s.json", scheme, url.PathEscape(name)))
+}
+
+func readSnapshot(path string) (*snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// 先写临时文件再重命名，避免进程中途退出留下损坏的快照
+func writeSnapshot(path string, s *snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// 加载快照填充缓存并标记为过期数据，直到第一次成功同步数据源
+func (r *serviceResolver) loadSnapshot() bool {
+	if r.snapshotPath == "" {
+		return false
+	}
+
+	s, err := readSnapshot(r.snapshotPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			r.opts.Logger.Printf("Failed to load snapshot: %v", err)
+		}
+		return false
+	}
+
+	r.mu.Lock()
+	r.addressCache = s.Instances
+	if r.addressCache == nil {
+		r.addressCache = make(map[string]ServiceInfo)
+	}
+	r.serviceConfig = s.Config
+	r.syncedAt = s.UpdatedAt
+	r.stale = true
+	r.mu.Unlock()
+
+	r.opts.Logger.Printf("Loaded %d instances from snapshot %s (age %v)", len(s.Instances), r.snapshotPath, time.Since(s.UpdatedAt).Round(time.Second))
+	return true
+}
+
+// 保存当前缓存到快照文件
+func (r *serviceResolver) saveSnapshot() {
+	if r.snapshotPath == "" {
+		return
+	}
+
+	r.mu.RLock()
+	s := &snapshot{
+		Service:   r.target.Endpoint(),
+		UpdatedAt: r.syncedAt,
+		Instances: make(map[string]ServiceInfo, len(r.addressCache)),
+		Config:    r.serviceConfig,
+	}
+	for k, v := range r.addressCache {
+		s.Instances[k] = v
+	}
+	r.mu.RUnlock()
+
+	if err := writeSnapshot(r.snapshotPath, s); err != nil {
+		r.opts.Logger.Printf("Failed to save snapshot: %v", err)
+	}
+}
+
+// 标记缓存已无法与数据源保持同步
+func (r *serviceResolver) markStale() {
+	r.mu.Lock()
+	r.stale = true
+	r.mu.Unlock()
+}
+
+// 当前数据的过期时长：与数据源保持同步时为 0；
+// 从快照恢复或同步失败时为距最后一次成功同步的时间。没有任何数据时 ok 为 false
+func (r *serviceResolver) staleness() (age time.Duration, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.syncedAt.IsZero() {
+		return 0, false
+	}
+	if !r.stale {
+		return 0, true
+	}
+	return time.Since(r.syncedAt), true
+}
@@ -0,0 +1,13 @@
+//go:build unix
+
+package discovery
+
+import (
+	"os"
+	"syscall"
+)
+
+func ownedByCurrentUser(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Getuid()
+}
@@ -0,0 +1,184 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-semver/semver"
+)
+
+// 目标地址中选择版本的参数，如：
+//
+//	custom-etcd:///hello-service?version=v1.2.0   精确版本
+//	custom-etcd:///hello-service?version=1.2      1.2.x
+//	custom-etcd:///hello-service?version=^1.2.0   >=1.2.0,<2.0.0
+//	custom-etcd:///hello-service?version=~1.2.0   >=1.2.0,<1.3.0
+//	custom-etcd:///hello-service?version=>=1.0.0,<2.0.0||>=3.0.0
+//
+// 实例的版本可以不完整（如 /services/hello-service/v1/ 下的实例），按其代表的系列匹配；
+// 不是语义化版本的值（如 canary）按字符串精确匹配
+const versionParam = "version"
+
+type versionConstraint struct {
+	op string
+	v  semver.Version
+}
+
+// parts 为实例版本给出的段数。不完整的版本（如 key 中的 v1）表示一个系列，
+// v1 即 [1.0.0, 2.0.0)，v1.2 即 [1.2.0, 1.3.0)，系列中存在满足约束的版本即视为匹配
+func (c versionConstraint) matches(v semver.Version, parts int) bool {
+	if parts >= 3 {
+		cmp := v.Compare(c.v)
+		switch c.op {
+		case ">":
+			return cmp > 0
+		case ">=":
+			return cmp >= 0
+		case "<":
+			return cmp < 0
+		case "<=":
+			return cmp <= 0
+		default:
+			return cmp == 0
+		}
+	}
+
+	lower, upper := v, v
+	if parts == 1 {
+		upper.BumpMajor()
+	} else {
+		upper.BumpMinor()
+	}
+	switch c.op {
+	case ">", ">=":
+		return c.v.LessThan(upper)
+	case "<":
+		return lower.LessThan(c.v)
+	case "<=":
+		return !c.v.LessThan(lower)
+	default:
+		return !c.v.LessThan(lower) && c.v.LessThan(upper)
+	}
+}
+
+// 版本选择器：|| 分隔的各组满足其一即可，组内逗号或空格分隔的约束需全部满足
+type versionSelector struct {
+	raw    string
+	groups [][]versionConstraint
+}
+
+func parseVersionSelector(raw string) (*versionSelector, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	s := &versionSelector{raw: raw}
+	if !strings.ContainsAny(raw, "^~<>=|, ") {
+		if _, _, err := parsePartialVersion(raw); err != nil {
+			// 非语义化版本，只做字符串精确匹配
+			return s, nil
+		}
+	}
+
+	for _, group := range strings.Split(raw, "||") {
+		var constraints []versionConstraint
+		for _, term := range strings.FieldsFunc(group, func(r rune) bool { return r == ',' || r == ' ' }) {
+			cs, err := parseVersionTerm(term)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version selector %q: %v", raw, err)
+			}
+			constraints = append(constraints, cs...)
+		}
+		if len(constraints) == 0 {
+			return nil, fmt.Errorf("invalid version selector %q: empty group", raw)
+		}
+		s.groups = append(s.groups, constraints)
+	}
+	return s, nil
+}
+
+// 解析单个约束，^、~ 以及不完整的版本号会展开成上下界两个约束
+func parseVersionTerm(term string) ([]versionConstraint, error) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(term, op) {
+			v, _, err := parsePartialVersion(term[len(op):])
+			if err != nil {
+				return nil, err
+			}
+			return []versionConstraint{{op: op, v: v}}, nil
+		}
+	}
+
+	prefix := ""
+	if strings.HasPrefix(term, "^") || strings.HasPrefix(term, "~") {
+		prefix, term = term[:1], term[1:]
+	}
+	v, parts, err := parsePartialVersion(term)
+	if err != nil {
+		return nil, err
+	}
+
+	upper := v
+	switch {
+	case prefix == "^" && v.Major > 0, prefix == "^" && parts == 1, prefix == "~" && parts == 1, prefix == "" && parts == 1:
+		upper.BumpMajor()
+	case prefix == "^", prefix == "~", prefix == "" && parts == 2:
+		upper.BumpMinor()
+	default:
+		// 完整版本号精确匹配
+		return []versionConstraint{{op: "=", v: v}}, nil
+	}
+	return []versionConstraint{{op: ">=", v: v}, {op: "<", v: upper}}, nil
+}
+
+// 解析可能不完整的版本号（v1、1.2、v1.2.3-rc.1），返回补齐后的版本和给出的段数
+func parsePartialVersion(s string) (semver.Version, int, error) {
+	s = strings.TrimPrefix(s, "v")
+	core, rest := s, ""
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		core, rest = s[:i], s[i:]
+	}
+
+	parts := strings.Count(core, ".") + 1
+	if parts > 3 {
+		return semver.Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	if parts < 3 && rest != "" {
+		return semver.Version{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	core += strings.Repeat(".0", 3-parts)
+
+	v, err := semver.NewVersion(core + rest)
+	if err != nil {
+		return semver.Version{}, 0, err
+	}
+	return *v, parts, nil
+}
+
+func (s *versionSelector) matches(version string) bool {
+	if s == nil {
+		return true
+	}
+	if version == s.raw || len(s.groups) == 0 {
+		return version == s.raw
+	}
+
+	v, parts, err := parsePartialVersion(version)
+	if err != nil {
+		return false
+	}
+	for _, group := range s.groups {
+		matched := true
+		for _, c := range group {
+			if !c.matches(v, parts) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
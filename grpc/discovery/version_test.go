@@ -0,0 +1,82 @@
+package discovery
+
+import "testing"
+
+func TestVersionSelector(t *testing.T) {
+	tests := []struct {
+		selector string
+		version  string
+		want     bool
+	}{
+		// 不带版本选择时匹配所有实例
+		{"", "", true},
+		{"", "v1.2.3", true},
+
+		// 不完整的版本号
+		{"v1", "v1", true},
+		{"v1", "v1.4.2", true},
+		{"v1", "v2", false},
+		{"v1", "v2.0.0", false},
+		{"v1", "", false},
+		{"1", "v1", true},
+		{"1.2", "v1.2", true},
+		{"1.2", "1.2.9", true},
+		{"1.2", "v1.3.0", false},
+		{"1.2", "v1.3", false},
+		{"v1.2", "v1", true},
+		{"v1.2.3", "v1.2", true},
+		{"v1.2.3", "v1.3", false},
+
+		// 精确版本
+		{"v1.2.0", "1.2.0", true},
+		{"v1.2.0", "v1.2.1", false},
+		{"v1.2.0-rc.1", "v1.2.0-rc.1", true},
+		{"v1.2.0", "v1.2.0-rc.1", false},
+
+		// ^ 和 ~
+		{"^1.2.0", "v1.2.0", true},
+		{"^1.2.0", "v1.9.9", true},
+		{"^1.2.0", "v1.1.9", false},
+		{"^1.2.0", "v2.0.0", false},
+		{"^1.2.0", "v1", true},
+		{"^1.2.0", "v2", false},
+		{"^0.2.0", "v0.2.5", true},
+		{"^0.2.0", "v0.3.0", false},
+		{"~1.2.0", "v1.2.7", true},
+		{"~1.2.0", "v1.3.0", false},
+
+		// 范围和 ||
+		{">=1.0.0,<2.0.0", "v1.5.0", true},
+		{">=1.0.0,<2.0.0", "v2.0.0", false},
+		{">=1.0.0 <2.0.0", "v0.9.0", false},
+		{">=1.0.0,<2.0.0||>=3.0.0", "v3.1.0", true},
+		{">=1.0.0,<2.0.0||>=3.0.0", "v2.5.0", false},
+		{">1.0.0", "v1.0.0", false},
+		{"<=1.0.0", "v1.0.0", true},
+		{">=2", "v2", true},
+		{"<2.0.0", "v2", false},
+
+		// 非语义化版本按字符串精确匹配
+		{"canary", "canary", true},
+		{"canary", "v1.0.0", false},
+		{"canary", "", false},
+		{"^1.0.0", "canary", false},
+	}
+	for _, tt := range tests {
+		s, err := parseVersionSelector(tt.selector)
+		if err != nil {
+			t.Fatalf("parseVersionSelector(%q): %v", tt.selector, err)
+		}
+		if got := s.matches(tt.version); got != tt.want {
+			t.Errorf("selector %q matches %q = %v, want %v", tt.selector, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestParseVersionSelectorErrors(t *testing.T) {
+	for _, selector := range []string{">=1.x", "^1.2.3.4", ">=1.0.0||", "1.2-rc.1 <2"} {
+		if _, err := parseVersionSelector(selector); err == nil {
+			t.Errorf("parseVersionSelector(%q) succeeded, want error", selector)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// lbbench 在进程内启动若干延迟不同的 HelloService 实例，
+// 用相同的并发压力依次测试各负载均衡策略，对比延迟分位数和流量分布：
+//
+//	go run ./lbbench -delays 5ms,5ms,5ms,50ms -concurrency 32 -requests 5000
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/orca"
+	"google.golang.org/grpc/peer"
+	"test/grpc/discovery"
+	"test/grpc/hello"
+)
+
+const (
+	benchScheme  = "lbbench"
+	benchService = "hello-service"
+
+	// 在途请求数达到该值时上报的利用率为 1
+	loadCapacity = 16
+)
+
+// 模拟固定延迟的实例，并通过 ORCA 上报在途请求占容量的比例
+type benchServer struct {
+	hello.UnimplementedHelloServiceServer
+	delay    time.Duration
+	inflight atomic.Int64
+}
+
+func (s *benchServer) SayHello(ctx context.Context, req *hello.HelloRequest) (*hello.HelloResponse, error) {
+	n := s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+	orca.CallMetricsRecorderFromContext(ctx).SetApplicationUtilization(float64(n) / loadCapacity)
+
+	// 在固定延迟上叠加 ±20% 抖动
+	delay := time.Duration(float64(s.delay) * (0.8 + 0.4*rand.Float64()))
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(delay):
+	}
+	return &hello.HelloResponse{Message: "Hello, " + req.Name}, nil
+}
+
+func startServers(delays []time.Duration) ([]discovery.ServiceInfo, func()) {
+	var infos []discovery.ServiceInfo
+	var servers []*grpc.Server
+	for _, delay := range delays {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		s := grpc.NewServer(orca.CallMetricsServerOption(nil))
+		hello.RegisterHelloServiceServer(s, &benchServer{delay: delay})
+		go s.Serve(l)
+
+		servers = append(servers, s)
+		infos = append(infos, discovery.ServiceInfo{Addr: l.Addr().String(), Weight: 1})
+	}
+	return infos, func() {
+		for _, s := range servers {
+			s.Stop()
+		}
+	}
+}
+
+type benchResult struct {
+	latencies []time.Duration
+	perAddr   map[string]int
+	errors    int
+}
+
+func runBench(builder *discovery.Builder, lbConfig string, concurrency, requests int) benchResult {
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:///%s", benchScheme, benchService),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithResolvers(builder),
+		grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [%s]}`, lbConfig)),
+	)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer conn.Close()
+	client := hello.NewHelloServiceClient(conn)
+
+	// 预热，等待所有连接建立
+	for i := 0; i < concurrency; i++ {
+		client.SayHello(context.Background(), &hello.HelloRequest{Name: "warmup"}, grpc.WaitForReady(true))
+	}
+
+	var mu sync.Mutex
+	result := benchResult{perAddr: make(map[string]int)}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(requests) {
+				var p peer.Peer
+				start := time.Now()
+				_, err := client.SayHello(context.Background(), &hello.HelloRequest{Name: "bench"}, grpc.Peer(&p))
+				elapsed := time.Since(start)
+
+				mu.Lock()
+				if err != nil {
+					result.errors++
+				} else {
+					result.latencies = append(result.latencies, elapsed)
+					result.perAddr[p.Addr.String()]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return result
+}
+
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(float64(len(sorted))*p), len(sorted)-1)]
+}
+
+func main() {
+	delaysFlag := flag.String("delays", "5ms,5ms,5ms,50ms", "comma separated backend latencies")
+	concurrency := flag.Int("concurrency", 32, "number of concurrent callers")
+	requests := flag.Int("requests", 5000, "requests per balancer")
+	flag.Parse()
+
+	var delays []time.Duration
+	for _, s := range strings.Split(*delaysFlag, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			log.Fatalf("invalid delay %q: %v", s, err)
+		}
+		delays = append(delays, d)
+	}
+
+	infos, stop := startServers(delays)
+	defer stop()
+
+	builder := discovery.NewBackendBuilder(
+		discovery.NewStaticBackend(map[string][]discovery.ServiceInfo{benchService: infos}),
+		// 不输出 resolver 日志，避免打乱结果表格
+		discovery.Options{Scheme: benchScheme, Logger: log.New(io.Discard, "", 0)},
+	)
+
+	balancers := []struct {
+		name   string
+		config string
+	}{
+		{"round_robin", `{"round_robin": {}}`},
+		{discovery.WeightedBalancerName, fmt.Sprintf(`{"%s": {}}`, discovery.WeightedBalancerName)},
+		{"least_request", fmt.Sprintf(`{"%s": {"mode": "%s"}}`, discovery.LeastLoadBalancerName, discovery.LeastLoadModeLeastRequest)},
+		{"peak_ewma", fmt.Sprintf(`{"%s": {"mode": "%s"}}`, discovery.LeastLoadBalancerName, discovery.LeastLoadModePeakEWMA)},
+		{"peak_ewma+orca", fmt.Sprintf(`{"%s": {"mode": "%s", "useServerLoad": true}}`, discovery.LeastLoadBalancerName, discovery.LeastLoadModePeakEWMA)},
+	}
+
+	fmt.Printf("backends: %v, concurrency: %d, requests: %d\n\n", delays, *concurrency, *requests)
+	fmt.Printf("%-30s %10s %10s %10s %10s %7s  %s\n", "balancer", "mean", "p50", "p90", "p99", "errors", "distribution")
+	for _, b := range balancers {
+		start := time.Now()
+		r := runBench(builder, b.config, *concurrency, *requests)
+		elapsed := time.Since(start)
+
+		slices.Sort(r.latencies)
+		var total time.Duration
+		for _, l := range r.latencies {
+			total += l
+		}
+		var mean time.Duration
+		if len(r.latencies) > 0 {
+			mean = total / time.Duration(len(r.latencies))
+		}
+
+		var dist []string
+		for i, info := range infos {
+			dist = append(dist, fmt.Sprintf("%v:%d%%", delays[i], r.perAddr[info.Addr]*100/max(len(r.latencies), 1)))
+		}
+		fmt.Printf("%-30s %10v %10v %10v %10v %7d  %s (%v)\n", b.name,
+			mean.Round(time.Microsecond), percentile(r.latencies, 0.5).Round(time.Microsecond),
+			percentile(r.latencies, 0.9).Round(time.Microsecond), percentile(r.latencies, 0.99).Round(time.Microsecond),
+			r.errors, strings.Join(dist, " "), elapsed.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"test/grpc/discovery"
+)
+
+// DiscoveryMetrics 实现 discovery.Metrics，通过 discovery.Options.Metrics 使用
+type DiscoveryMetrics struct {
+	instances  *prometheus.GaugeVec
+	selected   *prometheus.GaugeVec
+	lastUpdate *prometheus.GaugeVec
+	updates    *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+}
+
+var _ discovery.Metrics = (*DiscoveryMetrics)(nil)
+
+// NewDiscoveryMetrics 创建服务发现指标并注册到 reg
+func NewDiscoveryMetrics(reg prometheus.Registerer) *DiscoveryMetrics {
+	m := &DiscoveryMetrics{
+		instances: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "discovery_instances",
+			Help: "Number of instances registered for the service.",
+		}, []string{"service"}),
+		selected: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "discovery_selected_addresses",
+			Help: "Number of addresses sent to the ClientConn after filtering.",
+		}, []string{"service"}),
+		lastUpdate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "discovery_last_update_timestamp_seconds",
+			Help: "Unix time of the last successful sync with the backend.",
+		}, []string{"service"}),
+		updates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "discovery_updates_total",
+			Help: "Total number of updates (watch events) received from the backend.",
+		}, []string{"service"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "discovery_errors_total",
+			Help: "Total number of backend errors, such as failed etcd reads and watches.",
+		}, []string{"service"}),
+	}
+	reg.MustRegister(m.instances, m.selected, m.lastUpdate, m.updates, m.errors)
+	return m
+}
+
+func (m *DiscoveryMetrics) ObserveUpdate(service string) {
+	m.updates.WithLabelValues(service).Inc()
+}
+
+func (m *DiscoveryMetrics) ObserveError(service string, err error) {
+	m.errors.WithLabelValues(service).Inc()
+}
+
+func (m *DiscoveryMetrics) SetState(service string, registered, selected int, syncedAt time.Time) {
+	m.instances.WithLabelValues(service).Set(float64(registered))
+	m.selected.WithLabelValues(service).Set(float64(selected))
+	m.lastUpdate.WithLabelValues(service).Set(float64(syncedAt.UnixNano()) / 1e9)
+}
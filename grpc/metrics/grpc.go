@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// 调用类型标签
+const (
+	typeUnary        = "unary"
+	typeClientStream = "client_stream"
+	typeServerStream = "server_stream"
+	typeBidiStream   = "bidi_stream"
+)
+
+// ServerMetrics 记录 gRPC 服务端每个方法的调用次数、状态码和处理耗时，
+// 指标名与 go-grpc-prometheus 一致，可以复用现有的面板和告警
+type ServerMetrics struct {
+	started  *prometheus.CounterVec
+	handled  *prometheus.CounterVec
+	handling *prometheus.HistogramVec
+}
+
+// NewServerMetrics 创建服务端指标并注册到 reg
+func NewServerMetrics(reg prometheus.Registerer) *ServerMetrics {
+	m := &ServerMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_started_total",
+			Help: "Total number of RPCs started on the server.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "grpc_server_handled_total",
+			Help: "Total number of RPCs completed on the server, regardless of success or failure.",
+		}, []string{"grpc_type", "grpc_service", "grpc_method", "grpc_code"}),
+		handling: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "grpc_server_handling_seconds",
+			Help:    "Histogram of response latency of RPCs handled by the server.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+	}
+	reg.MustRegister(m.started, m.handled, m.handling)
+	return m
+}
+
+// 把 /package.Service/Method 拆分为服务名和方法名
+func splitMethod(fullMethod string) (service, method string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+func (m *ServerMetrics) observe(typ, fullMethod string, start time.Time, err error) {
+	service, method := splitMethod(fullMethod)
+	m.handled.WithLabelValues(typ, service, method, status.Code(err).String()).Inc()
+	m.handling.WithLabelValues(typ, service, method).Observe(time.Since(start).Seconds())
+}
+
+// UnaryServerInterceptor 记录一元调用，放在其他拦截器之前才能统计到认证失败和限流拒绝
+func (m *ServerMetrics) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	service, method := splitMethod(info.FullMethod)
+	m.started.WithLabelValues(typeUnary, service, method).Inc()
+
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	m.observe(typeUnary, info.FullMethod, start, err)
+	return resp, err
+}
+
+// StreamServerInterceptor 记录流式调用，耗时为整个流的持续时间
+func (m *ServerMetrics) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	typ := typeBidiStream
+	switch {
+	case info.IsClientStream && !info.IsServerStream:
+		typ = typeClientStream
+	case !info.IsClientStream && info.IsServerStream:
+		typ = typeServerStream
+	}
+	service, method := splitMethod(info.FullMethod)
+	m.started.WithLabelValues(typ, service, method).Inc()
+
+	start := time.Now()
+	err := handler(srv, ss)
+	m.observe(typ, info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// GatewayMetrics 记录 HTTP 网关每个路由的请求数、状态码、耗时和在途请求数
+type GatewayMetrics struct {
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inflight prometheus.Gauge
+}
+
+// NewGatewayMetrics 创建网关指标并注册到 reg
+func NewGatewayMetrics(reg prometheus.Registerer) *GatewayMetrics {
+	m := &GatewayMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gateway_http_requests_total",
+			Help: "Total number of HTTP requests handled by the gateway.",
+		}, []string{"method", "route", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gateway_http_request_duration_seconds",
+			Help:    "Histogram of HTTP request latency of the gateway.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		inflight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "gateway_http_requests_in_flight",
+			Help: "Number of HTTP requests currently being handled by the gateway.",
+		}),
+	}
+	reg.MustRegister(m.requests, m.duration, m.inflight)
+	return m
+}
+
+// Middleware 通过 runtime.WithMiddlewares 使用，只统计匹配到路由的请求，
+// 以路由模板（如 /v1/hello）而不是实际路径作为标签，避免路径参数导致标签过多
+func (m *GatewayMetrics) Middleware(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		route := "unknown"
+		if pattern, ok := runtime.HTTPPattern(r.Context()); ok {
+			route = pattern.String()
+		}
+
+		m.inflight.Inc()
+		defer m.inflight.Dec()
+
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next(rw, r, pathParams)
+
+		m.requests.WithLabelValues(r.Method, route, strconv.Itoa(rw.status)).Inc()
+		m.duration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// 记录响应状态码，网关的流式响应需要 Flush
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,26 @@
+// Package metrics 提供 Prometheus 监控指标：gRPC 服务端拦截器、HTTP 网关中间件，
+// 以及实现 discovery.Metrics 的服务发现指标
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// NewRegistry 创建独立的指标注册表，已包含 Go 运行时和进程指标
+func NewRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return reg
+}
+
+// Handler 以 Prometheus 文本格式输出 reg 中的指标，通常挂载在 /metrics
+func Handler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
@@ -0,0 +1,205 @@
+package policy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// 重试限流令牌桶，对冲请求共享同一个桶
+type throttler struct {
+	mu        sync.Mutex
+	max       float64
+	ratio     float64
+	threshold float64
+	tokens    float64
+}
+
+func newThrottler(t *Throttling) *throttler {
+	if t == nil {
+		return nil
+	}
+	return &throttler{
+		max:       t.MaxTokens,
+		ratio:     t.TokenRatio,
+		threshold: t.MaxTokens / 2,
+		tokens:    t.MaxTokens,
+	}
+}
+
+// 是否允许再发出一次额外尝试
+func (t *throttler) allow() bool {
+	if t == nil {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tokens > t.threshold
+}
+
+func (t *throttler) record(success bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if success {
+		t.tokens = min(t.tokens+t.ratio, t.max)
+	} else {
+		t.tokens = max(t.tokens-1, 0)
+	}
+}
+
+type hedger struct {
+	policy   *HedgingPolicy
+	methods  map[string]bool
+	throttle *throttler
+}
+
+func newHedger(c Config) *hedger {
+	h := &hedger{
+		policy:   c.Hedging,
+		methods:  make(map[string]bool, len(c.Methods)),
+		throttle: newThrottler(c.Throttling),
+	}
+	for _, m := range c.Methods {
+		h.methods[m] = true
+	}
+	return h
+}
+
+type hedgeResult struct {
+	reply proto.Message
+	err   error
+	info  *callInfo
+}
+
+// 单次尝试的 header、trailer 和对端地址。并发的对冲请求不能写入调用方的同一个目标，
+// 每次尝试单独接收，返回时把被采用的那一次复制给调用方
+type callInfo struct {
+	header  metadata.MD
+	trailer metadata.MD
+	peer    peer.Peer
+}
+
+// 调用方通过 grpc.Header、grpc.Trailer、grpc.Peer 传入的接收目标
+type callInfoTargets struct {
+	header  *metadata.MD
+	trailer *metadata.MD
+	peer    *peer.Peer
+}
+
+// 从 opts 中取出接收目标，返回其余的选项
+func splitCallInfoOptions(opts []grpc.CallOption) ([]grpc.CallOption, callInfoTargets) {
+	var targets callInfoTargets
+	rest := make([]grpc.CallOption, 0, len(opts))
+	for _, o := range opts {
+		switch o := o.(type) {
+		case grpc.HeaderCallOption:
+			targets.header = o.HeaderAddr
+		case grpc.TrailerCallOption:
+			targets.trailer = o.TrailerAddr
+		case grpc.PeerCallOption:
+			targets.peer = o.PeerAddr
+		default:
+			rest = append(rest, o)
+		}
+	}
+	return rest, targets
+}
+
+func (t callInfoTargets) set(info *callInfo) {
+	if t.header != nil {
+		*t.header = info.header
+	}
+	if t.trailer != nil {
+		*t.trailer = info.trailer
+	}
+	if t.peer != nil {
+		*t.peer = info.peer
+	}
+}
+
+func (h *hedger) unaryInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	out, ok := reply.(proto.Message)
+	if !h.methods[method] || !ok {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	// 任意一个请求返回后取消其余仍在进行的请求
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	opts, targets := splitCallInfoOptions(opts)
+	maxAttempts := min(h.policy.MaxAttempts, maxAttemptsLimit)
+	results := make(chan hedgeResult, maxAttempts)
+	attempts, pending := 0, 0
+	launch := func() {
+		attempts++
+		pending++
+		r := out.ProtoReflect().New().Interface()
+		info := &callInfo{}
+		attemptOpts := append(opts[:len(opts):len(opts)], grpc.Header(&info.header), grpc.Trailer(&info.trailer), grpc.Peer(&info.peer))
+		go func() {
+			err := invoker(ctx, method, req, r, cc, attemptOpts...)
+			results <- hedgeResult{reply: r, err: err, info: info}
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(h.policy.HedgingDelay)
+	defer timer.Stop()
+
+	var lastErr error
+	var lastInfo *callInfo
+	for {
+		select {
+		case <-timer.C:
+			if attempts >= maxAttempts {
+				break
+			}
+			// 被限流时不发出请求，下一个延迟后再尝试
+			if h.throttle.allow() {
+				launch()
+			}
+			timer.Reset(h.policy.HedgingDelay)
+
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				h.throttle.record(true)
+				proto.Merge(out, res.reply)
+				targets.set(res.info)
+				return nil
+			}
+
+			code := status.Code(res.err)
+			if !containsCode(h.policy.NonFatalCodes, code) {
+				targets.set(res.info)
+				return res.err
+			}
+			h.throttle.record(false)
+			lastErr = res.err
+			lastInfo = res.info
+
+			// 可重试的失败立即发出下一个对冲请求，不再等待延迟
+			if attempts < maxAttempts && h.throttle.allow() {
+				launch()
+				timer.Reset(h.policy.HedgingDelay)
+			} else if pending == 0 {
+				targets.set(lastInfo)
+				return lastErr
+			}
+
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
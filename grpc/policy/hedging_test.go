@@ -0,0 +1,230 @@
+package policy
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	ecpb "test/grpc/hello"
+)
+
+const sayHello = "/hello.HelloService/SayHello"
+
+// 按尝试序号（从 1 开始）决定行为的测试服务，每次尝试在 header 和 trailer 中带上自己的序号
+type scriptedServer struct {
+	ecpb.UnimplementedHelloServiceServer
+	attempts atomic.Int32
+	handle   func(ctx context.Context, attempt int) error
+}
+
+func (s *scriptedServer) SayHello(ctx context.Context, req *ecpb.HelloRequest) (*ecpb.HelloResponse, error) {
+	attempt := int(s.attempts.Add(1))
+	md := metadata.Pairs("attempt", strconv.Itoa(attempt))
+	grpc.SetHeader(ctx, md)
+	grpc.SetTrailer(ctx, md)
+	if err := s.handle(ctx, attempt); err != nil {
+		return nil, err
+	}
+	return &ecpb.HelloResponse{Message: "attempt " + strconv.Itoa(attempt)}, nil
+}
+
+// 启动 bufconn 上的服务端，返回按 cfg 接入对冲拦截器的客户端
+func startHedging(t *testing.T, cfg Config, srv *scriptedServer) ecpb.HelloServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	ecpb.RegisterHelloServiceServer(s, srv)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	opts, err := cfg.DialOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient("passthrough:///bufnet", append([]grpc.DialOption{
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return ecpb.NewHelloServiceClient(conn)
+}
+
+func hedgingConfig(maxAttempts int, delay time.Duration, throttling *Throttling) Config {
+	return Config{
+		Methods: []string{sayHello},
+		Hedging: &HedgingPolicy{
+			MaxAttempts:   maxAttempts,
+			HedgingDelay:  delay,
+			NonFatalCodes: []codes.Code{codes.Unavailable},
+		},
+		Throttling: throttling,
+	}
+}
+
+// 阻塞到请求被取消，模拟慢实例
+func hang(ctx context.Context) error {
+	<-ctx.Done()
+	return status.FromContextError(ctx.Err()).Err()
+}
+
+func TestHedgeAfterDelay(t *testing.T) {
+	srv := &scriptedServer{handle: func(ctx context.Context, attempt int) error {
+		if attempt == 1 {
+			return hang(ctx)
+		}
+		return nil
+	}}
+	client := startHedging(t, hedgingConfig(3, 50*time.Millisecond, nil), srv)
+
+	start := time.Now()
+	resp, err := client.SayHello(context.Background(), &ecpb.HelloRequest{Name: "hedge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("second attempt sent after %v, want at least the hedging delay", elapsed)
+	}
+	if resp.Message != "attempt 2" {
+		t.Errorf("got %q, want the reply of attempt 2", resp.Message)
+	}
+}
+
+func TestHedgeImmediatelyOnNonFatalCode(t *testing.T) {
+	srv := &scriptedServer{handle: func(ctx context.Context, attempt int) error {
+		if attempt == 1 {
+			return status.Error(codes.Unavailable, "injected")
+		}
+		return nil
+	}}
+	// 对冲延迟远大于测试时长，第二次尝试只能由失败触发
+	client := startHedging(t, hedgingConfig(3, time.Minute, nil), srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := client.SayHello(ctx, &ecpb.HelloRequest{Name: "hedge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Message != "attempt 2" {
+		t.Errorf("got %q, want the reply of attempt 2", resp.Message)
+	}
+}
+
+func TestHedgeStopsOnFatalCode(t *testing.T) {
+	srv := &scriptedServer{handle: func(ctx context.Context, attempt int) error {
+		return status.Error(codes.InvalidArgument, "injected")
+	}}
+	client := startHedging(t, hedgingConfig(3, time.Minute, nil), srv)
+
+	_, err := client.SayHello(context.Background(), &ecpb.HelloRequest{Name: "hedge"})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got %v, want InvalidArgument", err)
+	}
+	if n := srv.attempts.Load(); n != 1 {
+		t.Errorf("%d attempts, want 1", n)
+	}
+}
+
+func TestHedgeAllAttemptsFail(t *testing.T) {
+	srv := &scriptedServer{handle: func(ctx context.Context, attempt int) error {
+		return status.Error(codes.Unavailable, "injected")
+	}}
+	client := startHedging(t, hedgingConfig(3, time.Minute, nil), srv)
+
+	_, err := client.SayHello(context.Background(), &ecpb.HelloRequest{Name: "hedge"})
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+	if n := srv.attempts.Load(); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+}
+
+func TestHedgeThrottled(t *testing.T) {
+	srv := &scriptedServer{handle: func(ctx context.Context, attempt int) error {
+		return status.Error(codes.Unavailable, "injected")
+	}}
+	// 令牌上限 4，降到一半（2）及以下后不再发出额外尝试
+	client := startHedging(t, hedgingConfig(5, time.Minute, &Throttling{MaxTokens: 4, TokenRatio: 0.5}), srv)
+
+	// 4 -> 3 时仍允许对冲，3 -> 2 后停止
+	for i, want := range []int32{2, 1, 1} {
+		srv.attempts.Store(0)
+		if _, err := client.SayHello(context.Background(), &ecpb.HelloRequest{Name: "hedge"}); status.Code(err) != codes.Unavailable {
+			t.Fatalf("call %d: got %v, want Unavailable", i, err)
+		}
+		if n := srv.attempts.Load(); n != want {
+			t.Errorf("call %d: %d attempts, want %d", i, n, want)
+		}
+	}
+}
+
+func TestThrottler(t *testing.T) {
+	th := newThrottler(&Throttling{MaxTokens: 10, TokenRatio: 1})
+	for range 5 {
+		if !th.allow() {
+			t.Fatal("throttled before tokens dropped to half")
+		}
+		th.record(false)
+	}
+	if th.allow() {
+		t.Fatal("allowed with tokens at half of maxTokens")
+	}
+	th.record(true)
+	if !th.allow() {
+		t.Error("still throttled after a success restored tokens")
+	}
+	for range 20 {
+		th.record(true)
+	}
+	if th.tokens != 10 {
+		t.Errorf("tokens = %v, want capped at 10", th.tokens)
+	}
+
+	// 未配置限流时总是允许
+	var none *throttler
+	none.record(false)
+	if !none.allow() {
+		t.Error("nil throttler blocked an attempt")
+	}
+}
+
+func TestHedgeCallInfoFromWinner(t *testing.T) {
+	srv := &scriptedServer{handle: func(ctx context.Context, attempt int) error {
+		if attempt == 1 {
+			return hang(ctx)
+		}
+		return nil
+	}}
+	client := startHedging(t, hedgingConfig(2, 20*time.Millisecond, nil), srv)
+
+	var header, trailer metadata.MD
+	var p peer.Peer
+	_, err := client.SayHello(context.Background(), &ecpb.HelloRequest{Name: "hedge"},
+		grpc.Header(&header), grpc.Trailer(&trailer), grpc.Peer(&p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := header.Get("attempt"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("header attempt = %v, want [2]", got)
+	}
+	if got := trailer.Get("attempt"); len(got) != 1 || got[0] != "2" {
+		t.Errorf("trailer attempt = %v, want [2]", got)
+	}
+	if p.Addr == nil {
+		t.Error("peer address not set")
+	}
+}
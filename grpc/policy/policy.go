@@ -0,0 +1,215 @@
+// Package policy 提供客户端调用策略：重试、对冲（hedging）以及重试限流
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// gRPC 对单次调用的最大尝试次数上限
+const maxAttemptsLimit = 5
+
+// 重试策略，使用 gRPC 原生的 retryPolicy 实现
+type RetryPolicy struct {
+	MaxAttempts       int
+	InitialBackoff    time.Duration
+	MaxBackoff        time.Duration
+	BackoffMultiplier float64
+	// 返回这些状态码时重试
+	RetryableCodes []codes.Code
+}
+
+// 对冲策略：首个请求在 HedgingDelay 内没有返回就并发再发一个，取最先成功的结果。
+// gRPC-Go 不支持 hedgingPolicy，由本包的客户端拦截器实现
+type HedgingPolicy struct {
+	MaxAttempts  int
+	HedgingDelay time.Duration
+	// 返回这些状态码时立即发出下一个对冲请求，其余错误直接返回
+	NonFatalCodes []codes.Code
+}
+
+// 重试限流（gRFC A6）：失败时令牌减 1，成功时加 TokenRatio，
+// 令牌不超过 MaxTokens 的一半时停止重试和对冲，避免故障时放大后端压力
+type Throttling struct {
+	MaxTokens  float64
+	TokenRatio float64
+}
+
+// 调用策略配置，Methods 为完整方法名，如 /hello.HelloService/SayHello。
+// 同一方法的重试和对冲互斥，设置了 Hedging 时不再生成 retryPolicy
+type Config struct {
+	Methods    []string
+	Retry      *RetryPolicy
+	Hedging    *HedgingPolicy
+	Throttling *Throttling
+}
+
+func (c *Config) validate() error {
+	if len(c.Methods) == 0 {
+		return errors.New("policy: no methods configured")
+	}
+	for _, m := range c.Methods {
+		if _, _, err := splitMethod(m); err != nil {
+			return err
+		}
+	}
+
+	if r := c.Retry; r != nil {
+		if r.MaxAttempts < 2 {
+			return fmt.Errorf("policy: retry maxAttempts must be at least 2, got %d", r.MaxAttempts)
+		}
+		if r.InitialBackoff <= 0 || r.MaxBackoff <= 0 || r.BackoffMultiplier <= 0 {
+			return errors.New("policy: retry backoff must be positive")
+		}
+		if len(r.RetryableCodes) == 0 {
+			return errors.New("policy: retry requires at least one retryable code")
+		}
+	}
+	if h := c.Hedging; h != nil {
+		if h.MaxAttempts < 2 {
+			return fmt.Errorf("policy: hedging maxAttempts must be at least 2, got %d", h.MaxAttempts)
+		}
+		if h.HedgingDelay < 0 {
+			return errors.New("policy: hedging delay must not be negative")
+		}
+	}
+	if t := c.Throttling; t != nil {
+		if t.MaxTokens <= 0 || t.MaxTokens > 1000 {
+			return fmt.Errorf("policy: throttling maxTokens must be in (0, 1000], got %v", t.MaxTokens)
+		}
+		if t.TokenRatio <= 0 {
+			return fmt.Errorf("policy: throttling tokenRatio must be positive, got %v", t.TokenRatio)
+		}
+	}
+	return nil
+}
+
+// ServiceConfig 把 retryPolicy 和 retryThrottling 合并进已有的 service config JSON
+// （可以为空），已有 methodConfig 中同名方法的条目会被补充，而不是重复添加
+func (c Config) ServiceConfig(base string) (string, error) {
+	if err := c.validate(); err != nil {
+		return "", err
+	}
+
+	sc := make(map[string]any)
+	if strings.TrimSpace(base) != "" {
+		if err := json.Unmarshal([]byte(base), &sc); err != nil {
+			return "", fmt.Errorf("policy: invalid base service config: %v", err)
+		}
+	}
+
+	if c.Retry != nil && c.Hedging == nil {
+		methodConfigs, _ := sc["methodConfig"].([]any)
+		for _, m := range c.Methods {
+			service, method, _ := splitMethod(m)
+			entry := findMethodConfig(methodConfigs, service, method)
+			if entry == nil {
+				entry = map[string]any{
+					"name": []any{map[string]any{"service": service, "method": method}},
+				}
+				methodConfigs = append(methodConfigs, entry)
+			}
+			entry["retryPolicy"] = c.Retry.toJSON()
+		}
+		sc["methodConfig"] = methodConfigs
+	}
+
+	if c.Throttling != nil {
+		sc["retryThrottling"] = map[string]any{
+			"maxTokens":  c.Throttling.MaxTokens,
+			"tokenRatio": c.Throttling.TokenRatio,
+		}
+	}
+
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// DialOptions 返回接入客户端所需的拦截器（目前只有对冲）
+func (c Config) DialOptions() ([]grpc.DialOption, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if c.Hedging == nil {
+		return nil, nil
+	}
+	return []grpc.DialOption{grpc.WithChainUnaryInterceptor(newHedger(c).unaryInterceptor)}, nil
+}
+
+func (r *RetryPolicy) toJSON() map[string]any {
+	return map[string]any{
+		"maxAttempts":          min(r.MaxAttempts, maxAttemptsLimit),
+		"initialBackoff":       durationJSON(r.InitialBackoff),
+		"maxBackoff":           durationJSON(r.MaxBackoff),
+		"backoffMultiplier":    r.BackoffMultiplier,
+		"retryableStatusCodes": codeNames(r.RetryableCodes),
+	}
+}
+
+// 查找 name 中包含指定方法的 methodConfig 条目
+func findMethodConfig(methodConfigs []any, service, method string) map[string]any {
+	for _, mc := range methodConfigs {
+		entry, ok := mc.(map[string]any)
+		if !ok {
+			continue
+		}
+		names, _ := entry["name"].([]any)
+		for _, n := range names {
+			name, _ := n.(map[string]any)
+			if name["service"] == service && name["method"] == method {
+				return entry
+			}
+		}
+	}
+	return nil
+}
+
+// 拆分 /package.Service/Method
+func splitMethod(fullMethod string) (service, method string, err error) {
+	parts := strings.Split(strings.TrimPrefix(fullMethod, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("policy: invalid method name %q, want /package.Service/Method", fullMethod)
+	}
+	return parts[0], parts[1], nil
+}
+
+// service config 中的时长格式，如 "0.1s"
+func durationJSON(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// 状态码转成 service config 使用的名字，如 DeadlineExceeded -> DEADLINE_EXCEEDED
+func codeNames(cs []codes.Code) []string {
+	names := make([]string, 0, len(cs))
+	for _, c := range cs {
+		var b strings.Builder
+		s := c.String()
+		for i, r := range s {
+			if i > 0 && r >= 'A' && r <= 'Z' && s[i-1] >= 'a' && s[i-1] <= 'z' {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		}
+		names = append(names, strings.ToUpper(b.String()))
+	}
+	return names
+}
+
+func containsCode(cs []codes.Code, c codes.Code) bool {
+	for _, v := range cs {
+		if v == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"test/grpc/auth"
+	"test/grpc/discovery"
+	ecpb "test/grpc/hello"
+	"test/grpc/metrics"
+	"test/grpc/policy"
+	"test/grpc/security"
+	"test/grpc/tracing"
+)
+
+const serviceKey = "hello-service"
+
+func callUnaryEcho(c ecpb.HelloServiceClient, message string) {
+	// 客户端 span 是 gRPC 调用 span 的父 span，traceparent 随请求传到服务端
+	ctx, span := tracing.Tracer().Start(context.Background(), "callUnaryEcho")
+	defer span.End()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	r, err := c.SayHello(ctx, &ecpb.HelloRequest{Name: message})
+	if err != nil {
+		if discovery.IsNoInstances(err) {
+			log.Printf("no instances available: %v", err)
+			return
+		}
+		// 失败的调用会被异常检测记录，不中断后续请求
+		log.Printf("could not greet: %v", err)
+		return
+	}
+	fmt.Println(r.Message)
+}
+
+func makeRPCs(cc *grpc.ClientConn, n int) {
+	hwc := ecpb.NewHelloServiceClient(cc)
+	for i := 0; i < n; i++ {
+		callUnaryEcho(hwc, fmt.Sprintf("request #%d", i+1))
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func makeStickyRPCs(cc *grpc.ClientConn, users []string) {
+	hwc := ecpb.NewHelloServiceClient(cc)
+	for _, user := range users {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-user-id", user)
+		r, err := hwc.SayHello(ctx, &ecpb.HelloRequest{Name: user})
+		cancel()
+		if err != nil {
+			log.Printf("could not greet %s: %v", user, err)
+			continue
+		}
+		fmt.Println(r.Message)
+	}
+}
+
+func main() {
+	// 指定 -tls-ca 时使用 TLS，再指定 -tls-cert/-tls-key 时使用 mTLS。
+	// 连接目标的 authority 是服务名而不是主机名，需要用 -tls-allowed-ids 按 SPIFFE ID 校验服务端：
+	//	go run ./resolver -tls-ca certs/ca.pem -tls-cert certs/client.pem -tls-key certs/client-key.pem \
+	//		-tls-allowed-ids spiffe://example.org/hello-service
+	var tlsCfg security.Config
+	tlsCfg.AddFlags(flag.CommandLine)
+	// 服务端开启认证时用 -token 或 -api-key 携带凭据
+	var callCreds auth.CallCredentials
+	callCreds.AddFlags(flag.CommandLine)
+	// 指定 -trace-exporter stdout 时把 span 打印到标准输出，otlp 时发送到 -otlp-endpoint
+	var tracingCfg tracing.Config
+	tracingCfg.AddFlags(flag.CommandLine)
+	// 指定后在该地址的 /metrics 上提供服务发现指标，发完请求后继续运行直到 Ctrl+C
+	metricsAddr := flag.String("metrics-addr", "", "serve resolver metrics on this address, e.g. :9100")
+	// 客户端自身所在位置，只在本地生效，每个客户端按自己的部署位置设置
+	region := flag.String("region", "us-west", "region of this client, used by locality failover")
+	zone := flag.String("zone", "a", "zone of this client, used by locality failover")
+	// 服务在 etcd 中的 key 前缀，需与服务端的 -key-prefix 一致，如 /env/prod/services/
+	keyPrefix := flag.String("key-prefix", discovery.DefaultKeyPrefix, "etcd key prefix (namespace) of the services, must match the servers")
+	flag.Parse()
+	callCreds.AllowInsecure = !tlsCfg.Enabled()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingCfg, "hello-resolver-client")
+	if err != nil {
+		log.Fatalf("Invalid tracing config: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	creds, closeCreds, err := security.DialCredentials(tlsCfg)
+	if err != nil {
+		log.Fatalf("Invalid TLS config: %v", err)
+	}
+	defer closeCreds()
+	// 所有连接共用的调用凭据和链路追踪
+	extraOpts := callCreds.DialOptions()
+	if tracingCfg.Enabled() {
+		extraOpts = append(extraOpts, tracing.DialOption())
+	}
+
+	// 创建 etcd 客户端
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{"localhost:2379"},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to etcd: %v", err)
+	}
+	defer etcdClient.Close()
+
+	// 服务实例由 server 启动时带租约自行注册，例如在本机启动三个实例：
+	//
+	//	go run ./server -grpc-addr :8080 -gateway-addr :9080 -instance-id instance1 -weight 3 -metadata region=us-west,zone=a
+	//	go run ./server -grpc-addr :8081 -gateway-addr :9081 -instance-id instance2 -weight 2 -metadata region=us-west,zone=b
+	//	go run ./server -grpc-addr :8082 -gateway-addr :9082 -instance-id instance3 -weight 1 -metadata region=us-east,zone=a
+	//
+	// 按环境隔离时服务端和客户端使用相同的 key 前缀，如都加上 -key-prefix /env/prod/services/
+
+	// 创建并注册自定义 resolver
+	// 实例列表保存到本地快照，etcd 不可用时重启也能使用上次的地址
+	snapshotDir, err := discovery.DefaultSnapshotDir()
+	if err != nil {
+		log.Printf("Snapshots disabled: %v", err)
+	}
+	reg := metrics.NewRegistry()
+	customBuilder := discovery.NewBuilder(etcdClient, discovery.Options{
+		KeyPrefix:   *keyPrefix,
+		SnapshotDir: snapshotDir,
+		Metrics:     metrics.NewDiscoveryMetrics(reg),
+		Locality:    discovery.Locality{Region: *region, Zone: *zone},
+	})
+	resolver.Register(customBuilder)
+
+	// 按 -region/-zone 给出的客户端位置（默认 us-west/a）：优先同 zone，不足时外溢到同 region，再到任意实例，
+	// 同一优先级内按 etcd 中的 Weight 平滑加权轮询；
+	// 每个地址都通过 grpc.health.v1 检查 HelloService，不健康的实例在恢复前不参与选择；
+	// 连续失败或成功率过低的实例会被驱逐一段时间，最多同时驱逐一半实例
+	loadBalancingConfig := fmt.Sprintf(`[{"%s": {
+			"minHealthyPercent": 50,
+			"outlierDetection": {"interval": "10s", "baseEjectionTime": "30s", "consecutiveFailures": 3, "maxEjectionPercent": 50}
+		}}]`, discovery.LocalityBalancerName)
+	serviceConfig := fmt.Sprintf(`{
+		"loadBalancingConfig": %s,
+		"healthCheckConfig": {"serviceName": "%s"}
+	}`, loadBalancingConfig, ecpb.HelloService_ServiceDesc.ServiceName)
+
+	// 服务级 service config 发布到 etcd，修改后所有客户端实时生效；
+	// etcd 中存在配置时它会整体取代上面客户端自己的默认配置，因此同样使用地域故障转移，
+	// 在此之上为 SayHello 设置超时。这里只放所有客户端共享的设置，客户端位置由各自的 Options.Locality 提供
+	etcdServiceConfig := fmt.Sprintf(`{
+		"loadBalancingConfig": %s,
+		"healthCheckConfig": {"serviceName": "%s"},
+		"methodConfig": [{
+			"name": [{"service": "%s", "method": "SayHello"}],
+			"timeout": "1s"
+		}]
+	}`, loadBalancingConfig, ecpb.HelloService_ServiceDesc.ServiceName, ecpb.HelloService_ServiceDesc.ServiceName)
+
+	// SayHello 遇到 UNAVAILABLE 时最多尝试 3 次，失败过多时由重试限流停止重试；
+	// 重试策略同时合并进 etcd 中的配置和客户端默认配置
+	callPolicy := policy.Config{
+		Methods: []string{"/" + ecpb.HelloService_ServiceDesc.ServiceName + "/SayHello"},
+		Retry: &policy.RetryPolicy{
+			MaxAttempts:       3,
+			InitialBackoff:    100 * time.Millisecond,
+			MaxBackoff:        time.Second,
+			BackoffMultiplier: 2,
+			RetryableCodes:    []codes.Code{codes.Unavailable},
+		},
+		Throttling: &policy.Throttling{MaxTokens: 10, TokenRatio: 0.1},
+	}
+	if etcdServiceConfig, err = callPolicy.ServiceConfig(etcdServiceConfig); err != nil {
+		log.Fatalf("Invalid call policy: %v", err)
+	}
+	if serviceConfig, err = callPolicy.ServiceConfig(serviceConfig); err != nil {
+		log.Fatalf("Invalid call policy: %v", err)
+	}
+	policyOpts, err := callPolicy.DialOptions()
+	if err != nil {
+		log.Fatalf("Invalid call policy: %v", err)
+	}
+
+	if err := discovery.PutServiceConfig(context.Background(), etcdClient, serviceKey, etcdServiceConfig, discovery.Options{KeyPrefix: *keyPrefix}); err != nil {
+		log.Printf("Failed to publish service config: %v", err)
+	}
+
+	// 创建 gRPC 连接
+	conn, err := grpc.NewClient(
+		fmt.Sprintf("%s:///%s", discovery.DefaultScheme, serviceKey),
+		append([]grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDefaultServiceConfig(serviceConfig),
+		}, append(policyOpts, extraOpts...)...)...,
+	)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// 发起 RPC 调用
+	makeRPCs(conn, 5)
+
+	// 粘性路由：按请求头 x-user-id 一致性哈希，同一用户总是落到同一实例；
+	// 忽略 etcd 中的服务级配置，使用这里指定的负载均衡策略
+	stickyConn, err := grpc.NewClient(
+		fmt.Sprintf("%s:///%s", discovery.DefaultScheme, serviceKey),
+		append([]grpc.DialOption{
+			grpc.WithTransportCredentials(creds),
+			grpc.WithDisableServiceConfig(),
+			grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig": [{"%s": {"hashHeader": "x-user-id"}}]}`,
+				discovery.ConsistentHashBalancerName)),
+		}, extraOpts...)...,
+	)
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	defer stickyConn.Close()
+
+	makeStickyRPCs(stickyConn, []string{"alice", "bob", "alice", "bob"})
+
+	if age, ok := customBuilder.Staleness(serviceKey); ok {
+		log.Printf("Address list staleness: %v", age)
+	}
+
+	if *metricsAddr != "" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler(reg))
+		metricsServer := &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("Metrics server error: %v", err)
+				stop()
+			}
+		}()
+		log.Printf("Serving metrics on %s/metrics, press Ctrl+C to exit", *metricsAddr)
+		<-ctx.Done()
+		metricsServer.Close()
+	}
+}
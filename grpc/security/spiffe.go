@@ -0,0 +1,98 @@
+package security
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// 解析 SPIFFE ID：spiffe://<trust-domain>/<path>
+func parseID(id string) (*url.URL, error) {
+	u, err := url.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "spiffe" || u.Host == "" {
+		return nil, errors.New("SPIFFE ID must look like spiffe://<trust-domain>/<path>")
+	}
+	if u.User != nil || u.Port() != "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, errors.New("SPIFFE ID must not contain user info, port, query or fragment")
+	}
+	return u, nil
+}
+
+// 证书中的 SPIFFE ID，按规范 URI SAN 中只能有一个 SPIFFE ID
+func spiffeID(cert *x509.Certificate) (string, error) {
+	var id string
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "spiffe" {
+			continue
+		}
+		if id != "" {
+			return "", errors.New("certificate contains more than one SPIFFE ID")
+		}
+		if _, err := parseID(uri.String()); err != nil {
+			return "", fmt.Errorf("invalid SPIFFE ID %s: %v", uri, err)
+		}
+		id = uri.String()
+	}
+	if id == "" {
+		return "", errors.New("certificate has no SPIFFE ID")
+	}
+	return id, nil
+}
+
+// 精确匹配，或者 allowed 以 /* 结尾时匹配其下的任意路径
+func matchID(allowed []string, id string) bool {
+	for _, a := range allowed {
+		if prefix, ok := strings.CutSuffix(a, "*"); ok && strings.HasSuffix(prefix, "/") {
+			if strings.HasPrefix(id, prefix) {
+				return true
+			}
+			continue
+		}
+		if a == id {
+			return true
+		}
+	}
+	return false
+}
+
+// 读取证书文件中的叶子证书
+func readLeafCertificate(certFile string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", certFile)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// CertificateID 读取证书文件中叶子证书的 SPIFFE ID
+func CertificateID(certFile string) (string, error) {
+	cert, err := readLeafCertificate(certFile)
+	if err != nil {
+		return "", err
+	}
+	return spiffeID(cert)
+}
+
+// CertificateNames 读取证书文件中叶子证书的 DNS 和 IP SAN，DNS 名称在前
+func CertificateNames(certFile string) ([]string, error) {
+	cert, err := readLeafCertificate(certFile)
+	if err != nil {
+		return nil, err
+	}
+	names := append([]string(nil), cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names, nil
+}
@@ -0,0 +1,383 @@
+// Package security 提供 gRPC 服务端、网关回环连接和客户端共用的 TLS/mTLS 配置，
+// 证书文件变化后自动重新加载，并支持按 SPIFFE ID 校验对端身份
+package security
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLS 配置。服务端必须提供证书和私钥；客户端只需要 CA，提供证书时用于 mTLS
+type Config struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// 校验对端证书的 CA，客户端为空时使用系统根证书
+	CAFile string `yaml:"caFile"`
+	// 服务端：要求客户端提供由 CA 签发的证书（mTLS）
+	RequireClientCert bool `yaml:"requireClientCert"`
+	// 客户端：校验服务端证书使用的主机名，为空时使用连接目标的 authority（目标为 IP 时按 IP SAN 校验）
+	ServerName string `yaml:"serverName"`
+	// 允许的对端 SPIFFE ID，如 spiffe://example.org/hello-service，以 /* 结尾时按前缀匹配。
+	// 客户端配置后按 SPIFFE ID 而不是主机名校验服务端
+	AllowedIDs []string `yaml:"allowedIDs"`
+}
+
+// Enabled 配置了证书或 CA 时启用 TLS
+func (c *Config) Enabled() bool {
+	return c.CertFile != "" || c.CAFile != ""
+}
+
+// AddFlags 注册客户端使用的 TLS 命令行参数
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.CertFile, "tls-cert", c.CertFile, "client certificate file for mTLS")
+	fs.StringVar(&c.KeyFile, "tls-key", c.KeyFile, "client private key file for mTLS")
+	fs.StringVar(&c.CAFile, "tls-ca", c.CAFile, "CA file to verify servers, enables TLS")
+	fs.StringVar(&c.ServerName, "tls-server-name", c.ServerName, "server name to verify")
+	fs.Func("tls-allowed-ids", "comma separated SPIFFE IDs allowed for servers", func(v string) error {
+		c.AllowedIDs = SplitIDs(v)
+		return nil
+	})
+}
+
+// SplitIDs 解析逗号分隔的 SPIFFE ID 列表
+func SplitIDs(v string) []string {
+	var ids []string
+	for _, id := range strings.Split(v, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Reloader 持有当前的证书和 CA，文件变化后原子替换，新建立的连接立即使用新证书
+type Reloader struct {
+	cfg  Config
+	cert atomic.Pointer[tls.Certificate]
+	// 为 nil 时使用系统根证书
+	pool atomic.Pointer[x509.CertPool]
+
+	// 已加载文件内容的摘要，只由 load 读写，用于忽略内容没有变化的目录事件
+	digest []byte
+
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+}
+
+// NewReloader 加载证书并开始监听文件变化
+func NewReloader(cfg Config) (*Reloader, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("tls: certificate and key files must be set together")
+	}
+	if cfg.RequireClientCert && cfg.CAFile == "" {
+		return nil, errors.New("tls: CA file is required to verify client certificates")
+	}
+	for _, id := range cfg.AllowedIDs {
+		if _, err := parseID(strings.TrimSuffix(id, "/*")); err != nil {
+			return nil, fmt.Errorf("tls: invalid allowed ID %q: %v", id, err)
+		}
+	}
+
+	r := &Reloader{cfg: cfg, done: make(chan struct{})}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("tls: failed to watch certificate files: %v", err)
+	}
+	// 监听所在目录而不是文件本身：证书通常以重命名的方式原子替换；Kubernetes Secret 挂载时
+	// tls.crt 是指向 ..data/tls.crt 的符号链接，更新只替换 ..data 链接，不会产生 tls.crt 的事件，
+	// 因此目录中的任何变化都会触发重新加载，内容没有变化时忽略
+	dirs := make(map[string]bool)
+	for _, f := range r.files() {
+		dirs[filepath.Dir(f)] = true
+	}
+	for dir := range dirs {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("tls: failed to watch %s: %v", dir, err)
+		}
+	}
+	r.watcher = watcher
+	go r.watch()
+	return r, nil
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f != "" {
+			files = append(files, filepath.Clean(f))
+		}
+	}
+	return files
+}
+
+// 读取证书、私钥和 CA，内容与上次加载相同时返回 false
+func (r *Reloader) load() (changed bool, err error) {
+	var certPEM, keyPEM, caPEM []byte
+	if r.cfg.CertFile != "" {
+		if certPEM, err = os.ReadFile(r.cfg.CertFile); err != nil {
+			return false, fmt.Errorf("tls: failed to read certificate: %v", err)
+		}
+		if keyPEM, err = os.ReadFile(r.cfg.KeyFile); err != nil {
+			return false, fmt.Errorf("tls: failed to read key: %v", err)
+		}
+	}
+	if r.cfg.CAFile != "" {
+		if caPEM, err = os.ReadFile(r.cfg.CAFile); err != nil {
+			return false, fmt.Errorf("tls: failed to read CA file: %v", err)
+		}
+	}
+
+	h := sha256.New()
+	for _, data := range [][]byte{certPEM, keyPEM, caPEM} {
+		fmt.Fprintf(h, "%d:", len(data))
+		h.Write(data)
+	}
+	digest := h.Sum(nil)
+	if bytes.Equal(digest, r.digest) {
+		return false, nil
+	}
+
+	var cert tls.Certificate
+	if certPEM != nil {
+		if cert, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+			return false, fmt.Errorf("tls: failed to load certificate: %v", err)
+		}
+	}
+	var pool *x509.CertPool
+	if caPEM != nil {
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return false, fmt.Errorf("tls: no certificates found in %s", r.cfg.CAFile)
+		}
+	}
+
+	if certPEM != nil {
+		r.cert.Store(&cert)
+	}
+	if pool != nil {
+		r.pool.Store(pool)
+	}
+	r.digest = digest
+	return true, nil
+}
+
+func (r *Reloader) watch() {
+	defer close(r.done)
+
+	for {
+		select {
+		case ev, ok := <-r.watcher.Events:
+			if !ok {
+				return
+			}
+			if ev.Op == fsnotify.Chmod {
+				continue
+			}
+			// 证书和私钥可能先后写入，中间状态加载失败时保留旧证书，等待下一次变化
+			changed, err := r.load()
+			if err != nil {
+				log.Printf("Failed to reload certificates after %s changed: %v", ev.Name, err)
+				continue
+			}
+			if changed {
+				log.Printf("Reloaded certificates after %s changed", ev.Name)
+			}
+		case err, ok := <-r.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watch certificate files error: %v", err)
+		}
+	}
+}
+
+// Close 停止监听文件变化
+func (r *Reloader) Close() error {
+	err := r.watcher.Close()
+	<-r.done
+	return err
+}
+
+// ServerTLSConfig 服务端 TLS 配置，每次握手都使用最新的证书和 CA
+func (r *Reloader) ServerTLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		},
+	}
+	if r.cfg.RequireClientCert {
+		// 由 verifyPeer 使用当前的 CA 校验证书链，CA 更新后无需重建配置。
+		// 使用 VerifyConnection 而不是 VerifyPeerCertificate：后者在会话恢复时不会被调用，
+		// 恢复的会话会绕过更新后的 CA
+		cfg.ClientAuth = tls.RequireAnyClientCert
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			return r.verifyPeer(rawCertificates(cs), x509.ExtKeyUsageClientAuth, "")
+		}
+	}
+	return cfg
+}
+
+// ClientTLSConfig 客户端 TLS 配置，有证书时用于 mTLS。
+// 配置了 AllowedIDs 时按 SPIFFE ID 校验服务端，否则按 serverName 校验主机名，两者都没有时返回错误
+func (r *Reloader) ClientTLSConfig(serverName string) (*tls.Config, error) {
+	if len(r.cfg.AllowedIDs) > 0 {
+		serverName = ""
+	} else if serverName == "" {
+		return nil, errors.New("tls: no server name or allowed IDs to verify the server")
+	}
+
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+		// 标准校验只能使用创建时的 CA，这里关闭它并在 VerifyConnection 中用当前的 CA 校验。
+		// 主机名使用这里确定的 serverName 而不是 cs.ServerName：目标为 IP 时后者为空
+		InsecureSkipVerify: true,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return r.verifyPeer(rawCertificates(cs), x509.ExtKeyUsageServerAuth, serverName)
+		},
+	}
+	if r.cert.Load() != nil {
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return r.cert.Load(), nil
+		}
+	}
+	return cfg, nil
+}
+
+func rawCertificates(cs tls.ConnectionState) [][]byte {
+	rawCerts := make([][]byte, len(cs.PeerCertificates))
+	for i, cert := range cs.PeerCertificates {
+		rawCerts[i] = cert.Raw
+	}
+	return rawCerts
+}
+
+// ServerCredentials gRPC 服务端凭据
+func (r *Reloader) ServerCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(r.ServerTLSConfig())
+}
+
+// ClientCredentials gRPC 客户端凭据，每次握手按 cfg.ServerName 或连接目标的 authority 校验服务端
+func (r *Reloader) ClientCredentials() credentials.TransportCredentials {
+	return &clientCredentials{r: r, serverName: r.cfg.ServerName}
+}
+
+// 客户端凭据：握手时才知道连接目标，需要按目标构造 TLS 配置
+type clientCredentials struct {
+	r          *Reloader
+	serverName string
+}
+
+func (c *clientCredentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	serverName := c.serverName
+	if serverName == "" {
+		serverName = authorityHost(authority)
+	}
+	cfg, err := c.r.ClientTLSConfig(serverName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return credentials.NewTLS(cfg).ClientHandshake(ctx, authority, rawConn)
+}
+
+func (c *clientCredentials) ServerHandshake(net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("tls: client credentials cannot be used by servers")
+}
+
+func (c *clientCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", SecurityVersion: "1.2", ServerName: c.serverName}
+}
+
+func (c *clientCredentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	return &clone
+}
+
+func (c *clientCredentials) OverrideServerName(serverName string) error {
+	c.serverName = serverName
+	return nil
+}
+
+// authority 中的主机部分，如 localhost:8080 → localhost，[::1]:8080 → ::1
+func authorityHost(authority string) string {
+	if host, _, err := net.SplitHostPort(authority); err == nil {
+		return host
+	}
+	return strings.Trim(authority, "[]")
+}
+
+// DialCredentials 根据配置返回客户端凭据，未启用 TLS 时返回 insecure 凭据；
+// 返回的 close 用于停止监听证书文件
+func DialCredentials(cfg Config) (creds credentials.TransportCredentials, close func(), err error) {
+	if !cfg.Enabled() {
+		return insecure.NewCredentials(), func() {}, nil
+	}
+	r, err := NewReloader(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r.ClientCredentials(), func() { r.Close() }, nil
+}
+
+// 用当前的 CA 校验证书链，serverName 非空时同时校验主机名，最后校验 SPIFFE ID
+func (r *Reloader) verifyPeer(rawCerts [][]byte, usage x509.ExtKeyUsage, serverName string) error {
+	if len(rawCerts) == 0 {
+		return errors.New("tls: no peer certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("tls: failed to parse peer certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         r.pool.Load(),
+		Intermediates: x509.NewCertPool(),
+		DNSName:       serverName,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return fmt.Errorf("tls: failed to verify peer certificate: %v", err)
+	}
+
+	if len(r.cfg.AllowedIDs) == 0 {
+		return nil
+	}
+	id, err := spiffeID(certs[0])
+	if err != nil {
+		return fmt.Errorf("tls: %v", err)
+	}
+	if !matchID(r.cfg.AllowedIDs, id) {
+		return fmt.Errorf("tls: peer identity %s is not allowed", id)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package security
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// 用 certgen 生成一套证书：ca.pem、server.pem（localhost、127.0.0.1、spiffe://<td>/hello-service）
+// 和 client.pem（spiffe://<td>/client），每次生成的 CA 都不同
+func generateCerts(t *testing.T, trustDomain string) string {
+	t.Helper()
+	dir := t.TempDir()
+	out, err := exec.Command("go", "run", "../certgen", "-out", dir, "-trust-domain", trustDomain).CombinedOutput()
+	if err != nil {
+		t.Fatalf("certgen failed: %v\n%s", err, out)
+	}
+	return dir
+}
+
+// 启动只做 TLS 握手的服务端，握手成功后写回 ok
+func startServer(t *testing.T, cfg Config) string {
+	t.Helper()
+	r, err := NewReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", r.ServerTLSConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if conn.(*tls.Conn).Handshake() == nil {
+					conn.Write([]byte("ok"))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// 以 authority 连接 addr，服务端确认握手成功后返回 nil。
+// TLS 1.3 下客户端先于服务端完成握手，服务端拒绝客户端证书的错误要在读取时才能得到
+func dial(t *testing.T, creds credentials.TransportCredentials, addr, authority string) error {
+	t.Helper()
+	raw, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, _, err := creds.ClientHandshake(ctx, authority, raw)
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, make([]byte, 2))
+	return err
+}
+
+func clientCreds(t *testing.T, cfg Config) credentials.TransportCredentials {
+	t.Helper()
+	r, err := NewReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r.ClientCredentials()
+}
+
+func TestServerVerification(t *testing.T) {
+	dir := generateCerts(t, "example.org")
+	file := func(name string) string { return filepath.Join(dir, name) }
+	addr := startServer(t, Config{CertFile: file("server.pem"), KeyFile: file("server-key.pem")})
+	_, port, _ := net.SplitHostPort(addr)
+
+	tests := []struct {
+		name      string
+		cfg       Config
+		authority string
+		wantErr   bool
+	}{
+		{"hostname", Config{CAFile: file("ca.pem")}, "localhost:" + port, false},
+		{"IP", Config{CAFile: file("ca.pem")}, addr, false},
+		{"wrong host", Config{CAFile: file("ca.pem")}, "example.com:" + port, true},
+		{"wrong IP", Config{CAFile: file("ca.pem")}, "127.0.0.2:" + port, true},
+		{"wrong server name", Config{CAFile: file("ca.pem"), ServerName: "example.com"}, addr, true},
+		{"server name", Config{CAFile: file("ca.pem"), ServerName: "localhost"}, "hello-service", false},
+		{"no name", Config{CAFile: file("ca.pem")}, "", true},
+		{"SPIFFE ID", Config{CAFile: file("ca.pem"), AllowedIDs: []string{"spiffe://example.org/hello-service"}}, "hello-service", false},
+		{"SPIFFE prefix", Config{CAFile: file("ca.pem"), AllowedIDs: []string{"spiffe://example.org/*"}}, "hello-service", false},
+		{"wrong SPIFFE ID", Config{CAFile: file("ca.pem"), AllowedIDs: []string{"spiffe://example.org/other"}}, addr, true},
+		{"wrong trust domain", Config{CAFile: file("ca.pem"), AllowedIDs: []string{"spiffe://other.org/hello-service"}}, addr, true},
+		// 只有客户端证书时使用系统根证书，测试 CA 签发的证书不受信任
+		{"system roots", Config{CertFile: file("client.pem"), KeyFile: file("client-key.pem")}, addr, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := dial(t, clientCreds(t, tt.cfg), addr, tt.authority)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dial(%q) error = %v, want error %v", tt.authority, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientVerification(t *testing.T) {
+	dir := generateCerts(t, "example.org")
+	other := generateCerts(t, "example.org")
+	file := func(name string) string { return filepath.Join(dir, name) }
+	addr := startServer(t, Config{
+		CertFile:          file("server.pem"),
+		KeyFile:           file("server-key.pem"),
+		CAFile:            file("ca.pem"),
+		RequireClientCert: true,
+		AllowedIDs:        []string{"spiffe://example.org/client"},
+	})
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{"allowed client", file("client.pem"), file("client-key.pem"), false},
+		{"no certificate", "", "", true},
+		{"SPIFFE ID not allowed", file("server.pem"), file("server-key.pem"), true},
+		{"untrusted CA", filepath.Join(other, "client.pem"), filepath.Join(other, "client-key.pem"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			creds := clientCreds(t, Config{CAFile: file("ca.pem"), CertFile: tt.certFile, KeyFile: tt.keyFile})
+			err := dial(t, creds, addr, addr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("dial error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// 按 Kubernetes Secret 挂载的方式轮换证书：tls 文件是指向 ..data/ 的符号链接，更新时只替换 ..data
+func TestRotation(t *testing.T) {
+	oldCerts := generateCerts(t, "example.org")
+	newCerts := generateCerts(t, "example.org")
+
+	mount := t.TempDir()
+	install := func(src, version string) {
+		t.Helper()
+		dst := filepath.Join(mount, version)
+		if err := os.Mkdir(dst, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"ca.pem", "server.pem", "server-key.pem"} {
+			data, err := os.ReadFile(filepath.Join(src, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dst, name), data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tmp := filepath.Join(mount, "..data_tmp")
+		if err := os.Symlink(version, tmp); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(mount, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	install(oldCerts, "..v1")
+	for _, name := range []string{"ca.pem", "server.pem", "server-key.pem"} {
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(mount, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addr := startServer(t, Config{
+		CertFile: filepath.Join(mount, "server.pem"),
+		KeyFile:  filepath.Join(mount, "server-key.pem"),
+	})
+	oldClient := clientCreds(t, Config{CAFile: filepath.Join(oldCerts, "ca.pem")})
+	newClient := clientCreds(t, Config{CAFile: filepath.Join(newCerts, "ca.pem")})
+
+	if err := dial(t, oldClient, addr, addr); err != nil {
+		t.Fatalf("dial before rotation: %v", err)
+	}
+	if err := dial(t, newClient, addr, addr); err == nil {
+		t.Fatal("dial with the new CA succeeded before rotation")
+	}
+
+	install(newCerts, "..v2")
+	deadline := time.Now().Add(5 * time.Second)
+	for dial(t, newClient, addr, addr) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("server did not reload the rotated certificate")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if err := dial(t, oldClient, addr, addr); err == nil || !strings.Contains(err.Error(), "verify") {
+		t.Errorf("dial with the old CA after rotation: %v, want verification error", err)
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"test/grpc/auth"
+	"test/grpc/security"
+	"test/grpc/tracing"
+)
+
+// 服务端配置，优先级从低到高：默认值、YAML 配置文件、环境变量、命令行参数。
+// 在同一台机器上启动多个实例时只需改监听地址、实例 ID 等少数参数：
+//
+//	go run ./server -grpc-addr :8082 -gateway-addr :9082 -instance-id instance3 -weight 1 -metadata region=us-east,zone=a
+type config struct {
+	ServiceName string `yaml:"serviceName"`
+	// 实例 ID，默认为 <hostname>-<pid>
+	InstanceID string `yaml:"instanceID"`
+	// gRPC 监听地址
+	GRPCAddr string `yaml:"grpcAddr"`
+	// HTTP 网关监听地址，单端口模式下忽略
+	GatewayAddr string `yaml:"gatewayAddr"`
+	// 单端口模式：gRPC 和 HTTP 网关都在 GRPCAddr 上提供，按 content-type 分流
+	SinglePort bool `yaml:"singlePort"`
+	// 注册到 etcd 的地址，默认由 GRPCAddr 推导，监听所有网卡时使用 localhost
+	AdvertiseAddr string   `yaml:"advertiseAddr"`
+	EtcdEndpoints []string `yaml:"etcdEndpoints"`
+	// 注册到 etcd 的 key 前缀（命名空间），如 /env/prod/services/，客户端需使用相同的前缀，
+	// 默认 discovery.DefaultKeyPrefix
+	KeyPrefix string            `yaml:"keyPrefix"`
+	Weight    int               `yaml:"weight"`
+	Version   string            `yaml:"version"`
+	Metadata  map[string]string `yaml:"metadata"`
+
+	HealthCheck struct {
+		Interval time.Duration `yaml:"interval"`
+		Timeout  time.Duration `yaml:"timeout"`
+	} `yaml:"healthCheck"`
+
+	Shutdown struct {
+		// 摘除流量后的等待时间
+		DrainDelay time.Duration `yaml:"drainDelay"`
+		// 排空在途请求的总时限
+		Timeout time.Duration `yaml:"timeout"`
+	} `yaml:"shutdown"`
+
+	// 在途请求数达到该值时上报的利用率为 1
+	LoadCapacity int `yaml:"loadCapacity"`
+
+	Fault faultConfig `yaml:"fault"`
+
+	// 按调用方限速和限制并发，gRPC 和网关的请求同样生效
+	Limit limitConfig `yaml:"limit"`
+
+	// 配置了证书时 gRPC、网关和回环连接都使用 TLS，RequireClientCert 开启 mTLS
+	TLS security.Config `yaml:"tls"`
+
+	// 配置了 JWT 或 API key 时开启认证，按方法的访问规则只能在配置文件中设置
+	Auth auth.Config `yaml:"auth"`
+
+	// 配置了导出方式时开启链路追踪
+	Tracing tracing.Config `yaml:"tracing"`
+}
+
+func defaultConfig() *config {
+	cfg := &config{
+		ServiceName:   "hello-service",
+		GRPCAddr:      ":8080",
+		GatewayAddr:   ":9080",
+		EtcdEndpoints: []string{"localhost:2379"},
+		Weight:        1,
+		LoadCapacity:  100,
+	}
+	cfg.HealthCheck.Interval = 5 * time.Second
+	cfg.HealthCheck.Timeout = time.Second
+	cfg.Shutdown.DrainDelay = 2 * time.Second
+	cfg.Shutdown.Timeout = 15 * time.Second
+	return cfg
+}
+
+// 可以通过环境变量和命令行参数覆盖的配置项
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	set   func(cfg *config, value string) error
+}
+
+var settings = []setting{
+	{"service-name", "HELLO_SERVICE_NAME", "service name registered to etcd", func(c *config, v string) error {
+		c.ServiceName = v
+		return nil
+	}},
+	{"instance-id", "HELLO_INSTANCE_ID", "instance ID registered to etcd (default <hostname>-<pid>)", func(c *config, v string) error {
+		c.InstanceID = v
+		return nil
+	}},
+	{"grpc-addr", "HELLO_GRPC_ADDR", "gRPC listen address", func(c *config, v string) error {
+		c.GRPCAddr = v
+		return nil
+	}},
+	{"gateway-addr", "HELLO_GATEWAY_ADDR", "HTTP gateway listen address", func(c *config, v string) error {
+		c.GatewayAddr = v
+		return nil
+	}},
+	{"advertise-addr", "HELLO_ADVERTISE_ADDR", "address registered to etcd (default derived from -grpc-addr)", func(c *config, v string) error {
+		c.AdvertiseAddr = v
+		return nil
+	}},
+	{"etcd-endpoints", "HELLO_ETCD_ENDPOINTS", "comma separated etcd endpoints", func(c *config, v string) error {
+		c.EtcdEndpoints = splitList(v)
+		return nil
+	}},
+	{"key-prefix", "HELLO_KEY_PREFIX", "etcd key prefix (namespace) to register under, e.g. /env/prod/services/", func(c *config, v string) error {
+		c.KeyPrefix = v
+		return nil
+	}},
+	{"weight", "HELLO_WEIGHT", "load balancing weight", func(c *config, v string) error {
+		w, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		c.Weight = w
+		return nil
+	}},
+	{"version", "HELLO_VERSION", "API version of this instance", func(c *config, v string) error {
+		c.Version = v
+		return nil
+	}},
+	{"metadata", "HELLO_METADATA", "comma separated key=value metadata, e.g. region=us-west,zone=a", func(c *config, v string) error {
+		md, err := parseMetadata(v)
+		if err != nil {
+			return err
+		}
+		c.Metadata = md
+		return nil
+	}},
+	{"tls-cert", "HELLO_TLS_CERT", "server certificate file, enables TLS", func(c *config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key", "HELLO_TLS_KEY", "server private key file", func(c *config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"tls-ca", "HELLO_TLS_CA", "CA file to verify client certificates", func(c *config, v string) error {
+		c.TLS.CAFile = v
+		return nil
+	}},
+	{"tls-allowed-ids", "HELLO_TLS_ALLOWED_IDS", "comma separated SPIFFE IDs allowed for clients, /* suffix matches a path prefix", func(c *config, v string) error {
+		c.TLS.AllowedIDs = security.SplitIDs(v)
+		return nil
+	}},
+	{"auth-jwt-secret", "HELLO_AUTH_JWT_SECRET", "HMAC secret to verify HS256/HS384/HS512 tokens", func(c *config, v string) error {
+		c.Auth.JWT.HMACSecret = v
+		return nil
+	}},
+	{"auth-jwks-file", "HELLO_AUTH_JWKS_FILE", "JWKS file to verify RS*/PS*/ES* tokens", func(c *config, v string) error {
+		c.Auth.JWT.JWKSFile = v
+		return nil
+	}},
+	{"auth-jwt-issuer", "HELLO_AUTH_JWT_ISSUER", "required token issuer", func(c *config, v string) error {
+		c.Auth.JWT.Issuer = v
+		return nil
+	}},
+	{"auth-jwt-audience", "HELLO_AUTH_JWT_AUDIENCE", "required token audience", func(c *config, v string) error {
+		c.Auth.JWT.Audience = v
+		return nil
+	}},
+	{"auth-api-keys", "HELLO_AUTH_API_KEYS", "comma separated key=caller API keys", func(c *config, v string) error {
+		keys, err := parseMetadata(v)
+		if err != nil {
+			return err
+		}
+		c.Auth.APIKeys = keys
+		return nil
+	}},
+	{"rate-limit", "HELLO_RATE_LIMIT", "requests per second allowed for each caller, 0 disables rate limiting", func(c *config, v string) error {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		c.Limit.Rate = rate
+		return nil
+	}},
+	{"rate-limit-burst", "HELLO_RATE_LIMIT_BURST", "burst size of each caller's token bucket (default the rate)", func(c *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		c.Limit.Burst = n
+		return nil
+	}},
+	{"rate-limit-key", "HELLO_RATE_LIMIT_KEY", "how callers are identified: caller, ip or api-key", func(c *config, v string) error {
+		c.Limit.KeyBy = v
+		return nil
+	}},
+	{"max-concurrent-per-caller", "HELLO_MAX_CONCURRENT_PER_CALLER", "in-flight requests allowed for each caller, 0 means unlimited", func(c *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		c.Limit.MaxConcurrentPerCaller = n
+		return nil
+	}},
+	{"max-concurrent", "HELLO_MAX_CONCURRENT", "in-flight requests allowed for this instance, 0 means unlimited", func(c *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		c.Limit.MaxConcurrent = n
+		return nil
+	}},
+	{"min-concurrent", "HELLO_MIN_CONCURRENT", "lower bound of the adaptive concurrency limit", func(c *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		c.Limit.MinConcurrent = n
+		return nil
+	}},
+	{"trace-exporter", "HELLO_TRACE_EXPORTER", "trace exporter: stdout or otlp, empty disables tracing", func(c *config, v string) error {
+		c.Tracing.Exporter = v
+		return nil
+	}},
+	{"otlp-endpoint", "HELLO_OTLP_ENDPOINT", "OTLP gRPC endpoint (default localhost:4317)", func(c *config, v string) error {
+		c.Tracing.Endpoint = v
+		return nil
+	}},
+	{"trace-sample-ratio", "HELLO_TRACE_SAMPLE_RATIO", "fraction of root spans to sample (default 1)", func(c *config, v string) error {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		c.Tracing.SampleRatio = ratio
+		return nil
+	}},
+	{"fault-error-rate", "FAULT_ERROR_RATE", "probability of injected errors, e.g. 0.3", func(c *config, v string) error {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		c.Fault.ErrorRate = rate
+		return nil
+	}},
+	{"fault-error-code", "FAULT_ERROR_CODE", "status code of injected errors, e.g. UNAVAILABLE", func(c *config, v string) error {
+		c.Fault.ErrorCode = v
+		return nil
+	}},
+	{"fault-delay", "FAULT_DELAY", "delay injected before each request, e.g. 200ms", func(c *config, v string) error {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		c.Fault.Delay = d
+		return nil
+	}},
+}
+
+// 布尔配置项，命令行中可以不带值
+var boolSettings = []setting{
+	{"single-port", "HELLO_SINGLE_PORT", "serve gRPC and the HTTP gateway on -grpc-addr", func(c *config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.SinglePort = b
+		return nil
+	}},
+	{"adaptive-concurrency", "HELLO_ADAPTIVE_CONCURRENCY", "adjust the instance concurrency limit between -min-concurrent and -max-concurrent by latency", func(c *config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Limit.Adaptive = b
+		return nil
+	}},
+	{"otlp-insecure", "HELLO_OTLP_INSECURE", "connect to the OTLP endpoint without TLS", func(c *config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.Tracing.Insecure = b
+		return nil
+	}},
+	{"tls-client-auth", "HELLO_TLS_CLIENT_AUTH", "require and verify client certificates (mTLS)", func(c *config, v string) error {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return err
+		}
+		c.TLS.RequireClientCert = b
+		return nil
+	}},
+}
+
+// 读取配置，配置文件路径来自 -config 参数或 HELLO_CONFIG 环境变量
+func loadConfig(args []string) (*config, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("HELLO_CONFIG"), "path to YAML config file (env HELLO_CONFIG)")
+
+	// 命令行参数在读完配置文件和环境变量后再应用
+	type flagValue struct {
+		setting *setting
+		value   string
+	}
+	var flagValues []flagValue
+	all := append(append([]setting(nil), settings...), boolSettings...)
+	for i := range all {
+		s := &all[i]
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env)
+		fn := func(v string) error {
+			flagValues = append(flagValues, flagValue{s, v})
+			return nil
+		}
+		if i >= len(settings) {
+			fs.BoolFunc(s.flag, usage, fn)
+		} else {
+			fs.Func(s.flag, usage, fn)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", *configPath, err)
+		}
+	}
+
+	for _, s := range all {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(cfg, v); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %v", s.env, v, err)
+			}
+		}
+	}
+	for _, fv := range flagValues {
+		if err := fv.setting.set(cfg, fv.value); err != nil {
+			return nil, fmt.Errorf("invalid -%s %q: %v", fv.setting.flag, fv.value, err)
+		}
+	}
+
+	if cfg.InstanceID == "" {
+		hostname, _ := os.Hostname()
+		cfg.InstanceID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if cfg.AdvertiseAddr == "" {
+		cfg.AdvertiseAddr = hostAddr(cfg.GRPCAddr, "localhost")
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *config) validate() error {
+	switch {
+	case c.ServiceName == "":
+		return errors.New("service name is required")
+	case c.GRPCAddr == "":
+		return errors.New("gRPC address is required")
+	case c.GatewayAddr == "" && !c.SinglePort:
+		return errors.New("gateway address is required unless single port mode is enabled")
+	case len(c.EtcdEndpoints) == 0:
+		return errors.New("at least one etcd endpoint is required")
+	case c.Weight <= 0:
+		return fmt.Errorf("weight must be positive, got %d", c.Weight)
+	case c.HealthCheck.Interval <= 0 || c.HealthCheck.Timeout <= 0:
+		return errors.New("health check interval and timeout must be positive")
+	case c.Shutdown.DrainDelay < 0 || c.Shutdown.Timeout <= 0:
+		return errors.New("shutdown drain delay must not be negative and timeout must be positive")
+	case c.TLS.Enabled() && (c.TLS.CertFile == "" || c.TLS.KeyFile == ""):
+		return errors.New("TLS requires both certificate and key files")
+	}
+	return nil
+}
+
+// 把监听地址转换为可以连接的地址，监听所有网卡（":8080"、"0.0.0.0:8080"）时使用 host 代替
+func hostAddr(listenAddr, host string) string {
+	h, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	if ip := net.ParseIP(h); h == "" || (ip != nil && ip.IsUnspecified()) {
+		h = host
+	}
+	return net.JoinHostPort(h, port)
+}
+
+func splitList(v string) []string {
+	var result []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func parseMetadata(v string) (map[string]string, error) {
+	md := make(map[string]string)
+	for _, kv := range splitList(v) {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", kv)
+		}
+		md[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return md, nil
+}
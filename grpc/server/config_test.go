@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultConfigPorts(t *testing.T) {
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GRPCAddr == cfg.GatewayAddr {
+		t.Errorf("default gRPC and gateway addresses are both %s", cfg.GRPCAddr)
+	}
+	// 示例中同一台机器上的其它实例使用 :8081、:8082 作为 gRPC 端口
+	for _, addr := range []string{":8081", ":8082"} {
+		if cfg.GatewayAddr == addr {
+			t.Errorf("default gateway address %s collides with another instance's gRPC address", addr)
+		}
+	}
+	if cfg.KeyPrefix != "" {
+		t.Errorf("default key prefix = %q, want empty (discovery default)", cfg.KeyPrefix)
+	}
+}
+
+// 命令行参数优先于环境变量，环境变量优先于配置文件
+func TestKeyPrefixPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("keyPrefix: /env/file/services/\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := loadConfig([]string{"-config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KeyPrefix != "/env/file/services/" {
+		t.Errorf("key prefix from file = %q", cfg.KeyPrefix)
+	}
+
+	t.Setenv("HELLO_KEY_PREFIX", "/env/staging/services/")
+	if cfg, err = loadConfig([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KeyPrefix != "/env/staging/services/" {
+		t.Errorf("key prefix from env = %q", cfg.KeyPrefix)
+	}
+
+	if cfg, err = loadConfig([]string{"-config", path, "-key-prefix", "/env/prod/services/"}); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KeyPrefix != "/env/prod/services/" {
+		t.Errorf("key prefix from flag = %q", cfg.KeyPrefix)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 故障注入配置，也可以通过环境变量设置：
+// FAULT_ERROR_RATE=0.3 FAULT_ERROR_CODE=UNAVAILABLE FAULT_DELAY=200ms
+type faultConfig struct {
+	ErrorRate float64       `yaml:"errorRate"`
+	ErrorCode string        `yaml:"errorCode"`
+	Delay     time.Duration `yaml:"delay"`
+}
+
+// 故障注入，用于在本地验证客户端的重试、对冲和异常检测：
+// 按 errorRate 的概率返回 errorCode，并在处理前固定延迟 delay。
+// 携带内部 key 的回环健康检查不参与故障注入
+type faultInjector struct {
+	errorRate   float64
+	errorCode   codes.Code
+	delay       time.Duration
+	internalKey string
+}
+
+func newFaultInjector(cfg faultConfig, internalKey string) (*faultInjector, error) {
+	f := &faultInjector{errorRate: cfg.ErrorRate, errorCode: codes.Unavailable, delay: cfg.Delay, internalKey: internalKey}
+
+	if cfg.ErrorRate < 0 || cfg.ErrorRate > 1 {
+		return nil, fmt.Errorf("invalid fault error rate %v", cfg.ErrorRate)
+	}
+	if cfg.ErrorCode != "" {
+		if err := f.errorCode.UnmarshalJSON([]byte(strconv.Quote(cfg.ErrorCode))); err != nil {
+			return nil, fmt.Errorf("invalid fault error code %q", cfg.ErrorCode)
+		}
+	}
+	if cfg.Delay < 0 {
+		return nil, fmt.Errorf("invalid fault delay %v", cfg.Delay)
+	}
+	return f, nil
+}
+
+func (f *faultInjector) enabled() bool {
+	return f.errorRate > 0 || f.delay > 0
+}
+
+func (f *faultInjector) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if hasInternalKey(ctx, f.internalKey) {
+		return handler(ctx, req)
+	}
+
+	if f.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-time.After(f.delay):
+		}
+	}
+	if f.errorRate > 0 && rand.Float64() < f.errorRate {
+		return nil, status.Errorf(f.errorCode, "injected fault for %s", info.FullMethod)
+	}
+	return handler(ctx, req)
+}
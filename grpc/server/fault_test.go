@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"test/grpc/auth"
+)
+
+func TestFaultInjectorBypass(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/hello.HelloService/SayHello"}
+	handler := func(context.Context, any) (any, error) { return "ok", nil }
+	incoming := func(kv ...string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(kv...))
+	}
+
+	tests := []struct {
+		name        string
+		internalKey string
+		ctx         context.Context
+		wantFault   bool
+	}{
+		{"no metadata", "internal-key", context.Background(), true},
+		// 客户端自己设置的 header（网关转发的 Grpc-Metadata-X-Health-Check 也一样）不能跳过故障注入
+		{"client health check header", "internal-key", incoming("x-health-check", "1"), true},
+		{"wrong key", "internal-key", incoming(auth.APIKeyKey, "guess"), true},
+		{"internal key", "internal-key", incoming(auth.APIKeyKey, "internal-key"), false},
+		{"internal key among others", "internal-key", incoming(auth.APIKeyKey, "demo-key", auth.APIKeyKey, "internal-key"), false},
+		{"no internal key configured", "", incoming(auth.APIKeyKey, ""), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := newFaultInjector(faultConfig{ErrorRate: 1, ErrorCode: "UNAVAILABLE"}, tt.internalKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = f.unaryInterceptor(tt.ctx, nil, info, handler)
+			if got := status.Code(err) == codes.Unavailable; got != tt.wantFault {
+				t.Errorf("fault injected = %v (err %v), want %v", got, err, tt.wantFault)
+			}
+		})
+	}
+}
+
+func TestNewFaultInjectorErrors(t *testing.T) {
+	for _, cfg := range []faultConfig{
+		{ErrorRate: -0.1},
+		{ErrorRate: 1.5},
+		{ErrorCode: "NOT_A_CODE"},
+		{Delay: -1},
+	} {
+		if _, err := newFaultInjector(cfg, ""); err == nil {
+			t.Errorf("newFaultInjector(%+v) succeeded, want error", cfg)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"test/grpc/auth"
+)
+
+// 请求是否携带本实例启动时随机生成的内部 key，只有本实例的回环健康检查知道这个 key。
+// 不能用客户端可以随意设置的 header 判断，网关也会把 Grpc-Metadata-* 请求头原样转发过来
+func hasInternalKey(ctx context.Context, internalKey string) bool {
+	if internalKey == "" {
+		return false
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, key := range md.Get(auth.APIKeyKey) {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(internalKey)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// 单个服务的健康检查函数，返回 nil 表示 SERVING
+type healthCheckFunc func(ctx context.Context) error
+
+// 按固定间隔执行各服务的检查，并把结果写入标准健康服务（grpc.health.v1）。
+// 空服务名代表整个进程，只有全部服务都健康时才为 SERVING
+type healthChecker struct {
+	server   *health.Server
+	interval time.Duration
+	timeout  time.Duration
+	checks   map[string]healthCheckFunc
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newHealthChecker(server *health.Server, interval, timeout time.Duration) *healthChecker {
+	return &healthChecker{
+		server:   server,
+		interval: interval,
+		timeout:  timeout,
+		checks:   make(map[string]healthCheckFunc),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// 注册服务的检查函数，必须在 start 之前调用
+func (c *healthChecker) register(service string, check healthCheckFunc) {
+	c.checks[service] = check
+}
+
+func (c *healthChecker) start() {
+	go func() {
+		defer close(c.done)
+
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.checkAll()
+			select {
+			case <-c.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *healthChecker) checkAll() {
+	overall := healthpb.HealthCheckResponse_SERVING
+	for service, check := range c.checks {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		err := check(ctx)
+		cancel()
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			log.Printf("Health check for %q failed: %v", service, err)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			overall = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		c.server.SetServingStatus(service, status)
+	}
+	c.server.SetServingStatus("", overall)
+}
+
+// 停止检查，之后的状态由调用方自行设置
+func (c *healthChecker) close() {
+	c.stopOnce.Do(func() { close(c.stop) })
+	<-c.done
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"test/grpc/auth"
+)
+
+const (
+	// 拒绝请求时在 header 中返回建议的重试间隔（秒），网关转换为 HTTP Retry-After 头
+	retryAfterKey = "retry-after"
+	// gRPC 客户端的重试策略按该 trailer 等待后再重试
+	retryPushbackKey = "grpc-retry-pushback-ms"
+	// 并发超限时建议的重试间隔，在途请求通常在这段时间内完成
+	concurrencyRetryAfter = time.Second
+	// 清理空闲调用方状态的间隔
+	limitSweepInterval = time.Minute
+)
+
+// 调用方的区分方式
+const (
+	// 认证后的调用方，未开启认证或公开方法没有携带凭据时使用来源 IP
+	limitKeyCaller = "caller"
+	limitKeyIP     = "ip"
+	// x-api-key 的值，没有携带时使用来源 IP；不校验 key 是否有效
+	limitKeyAPIKey = "api-key"
+)
+
+// 限流配置，也可以通过环境变量设置：
+// HELLO_RATE_LIMIT=50 HELLO_RATE_LIMIT_BURST=100 HELLO_MAX_CONCURRENT=200
+type limitConfig struct {
+	// 每个调用方每秒的请求数，0 表示不限速
+	Rate float64 `yaml:"rate"`
+	// 令牌桶容量，允许的突发请求数，默认等于 Rate
+	Burst int `yaml:"burst"`
+	// 调用方的区分方式：caller、ip 或 api-key
+	KeyBy string `yaml:"keyBy"`
+	// 每个调用方的在途请求上限，0 表示不限制
+	MaxConcurrentPerCaller int `yaml:"maxConcurrentPerCaller"`
+	// 实例的在途请求上限，0 表示不限制
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// 根据延迟变化在 [MinConcurrent, MaxConcurrent] 内自动调整实例的在途请求上限
+	Adaptive      bool `yaml:"adaptive"`
+	MinConcurrent int  `yaml:"minConcurrent"`
+}
+
+// 单个调用方的令牌桶和在途请求数
+type callerState struct {
+	tokens   float64
+	last     time.Time
+	inflight int
+}
+
+// 限流和并发控制，gRPC 请求和网关转发的请求都经过这里：
+// 先按调用方的令牌桶限速，再检查调用方和实例的在途请求数，超限时返回 RESOURCE_EXHAUSTED
+type limiter struct {
+	rate          float64
+	burst         float64
+	keyBy         string
+	maxPerCaller  int
+	adaptive      bool
+	minLimit      float64
+	maxLimit      float64
+	internalKey   string
+	bucketRefill  time.Duration
+	sweepInterval time.Duration
+	// 当前时间，测试时替换
+	now func() time.Time
+
+	mu        sync.Mutex
+	callers   map[string]*callerState
+	lastSweep time.Time
+	inflight  int
+	// 当前的实例在途请求上限，0 表示不限制
+	limit float64
+	// 自适应并发使用的短期和长期平均延迟（纳秒）
+	shortRTT float64
+	longRTT  float64
+}
+
+func newLimiter(cfg limitConfig, internalKey string) (*limiter, error) {
+	l := &limiter{
+		rate:          cfg.Rate,
+		burst:         float64(cfg.Burst),
+		keyBy:         cfg.KeyBy,
+		maxPerCaller:  cfg.MaxConcurrentPerCaller,
+		adaptive:      cfg.Adaptive,
+		minLimit:      float64(max(cfg.MinConcurrent, 1)),
+		maxLimit:      float64(cfg.MaxConcurrent),
+		internalKey:   internalKey,
+		sweepInterval: limitSweepInterval,
+		now:           time.Now,
+		callers:       make(map[string]*callerState),
+		limit:         float64(cfg.MaxConcurrent),
+	}
+	l.lastSweep = l.now()
+
+	switch l.keyBy {
+	case "":
+		l.keyBy = limitKeyCaller
+	case limitKeyCaller, limitKeyIP, limitKeyAPIKey:
+	default:
+		return nil, fmt.Errorf("invalid rate limit key %q, must be caller, ip or api-key", cfg.KeyBy)
+	}
+	if cfg.Rate < 0 || cfg.Burst < 0 {
+		return nil, fmt.Errorf("invalid rate limit %v with burst %d", cfg.Rate, cfg.Burst)
+	}
+	if cfg.MaxConcurrentPerCaller < 0 || cfg.MaxConcurrent < 0 || cfg.MinConcurrent < 0 {
+		return nil, fmt.Errorf("invalid concurrency limits: max %d, per caller %d, min %d",
+			cfg.MaxConcurrent, cfg.MaxConcurrentPerCaller, cfg.MinConcurrent)
+	}
+	if cfg.Adaptive && (cfg.MaxConcurrent == 0 || l.minLimit > l.maxLimit) {
+		return nil, fmt.Errorf("adaptive concurrency requires max concurrent (%d) to be at least min concurrent (%d)",
+			cfg.MaxConcurrent, cfg.MinConcurrent)
+	}
+	if l.rate > 0 {
+		if l.burst == 0 {
+			l.burst = math.Max(math.Ceil(l.rate), 1)
+		}
+		// 空闲这么久之后令牌桶一定是满的，可以删掉调用方的状态
+		l.bucketRefill = time.Duration(l.burst / l.rate * float64(time.Second))
+	}
+	return l, nil
+}
+
+func (l *limiter) enabled() bool {
+	return l.rate > 0 || l.maxPerCaller > 0 || l.maxLimit > 0
+}
+
+// 健康检查不受限制：客户端负载均衡器的 grpc.health.v1 检查和本实例的回环检查
+func (l *limiter) exempt(ctx context.Context, method string) bool {
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return true
+	}
+	return hasInternalKey(ctx, l.internalKey)
+}
+
+func (l *limiter) callerKey(ctx context.Context) string {
+	switch l.keyBy {
+	case limitKeyCaller:
+		if p, ok := auth.PrincipalFromContext(ctx); ok {
+			return p.Method + ":" + p.Name
+		}
+	case limitKeyAPIKey:
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if keys := md.Get(auth.APIKeyKey); len(keys) > 0 {
+				return "api-key:" + keys[0]
+			}
+		}
+	}
+	return "ip:" + clientIP(ctx)
+}
+
+// 请求的来源 IP。来自回环地址的请求由网关转发，网关把原始客户端地址追加在 x-forwarded-for 末尾
+func clientIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if fwd := md.Get("x-forwarded-for"); len(fwd) > 0 {
+				hops := strings.Split(fwd[len(fwd)-1], ",")
+				if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+					return last
+				}
+			}
+		}
+	}
+	return host
+}
+
+// 获取一个令牌和并发名额，成功时返回请求结束后调用的 release，失败时返回拒绝原因和建议的重试间隔
+func (l *limiter) acquire(key string) (release func(), reason string, retryAfter time.Duration) {
+	now := l.now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= l.sweepInterval {
+		l.sweep(now)
+	}
+
+	st, ok := l.callers[key]
+	if !ok {
+		st = &callerState{tokens: l.burst, last: now}
+		l.callers[key] = st
+	}
+	if l.rate > 0 {
+		st.tokens = math.Min(l.burst, st.tokens+now.Sub(st.last).Seconds()*l.rate)
+		st.last = now
+		if st.tokens < 1 {
+			return nil, "rate limit exceeded", time.Duration((1 - st.tokens) / l.rate * float64(time.Second))
+		}
+	}
+	if l.maxPerCaller > 0 && st.inflight >= l.maxPerCaller {
+		return nil, "too many concurrent requests from caller", concurrencyRetryAfter
+	}
+	if l.limit > 0 && l.inflight >= int(l.limit) {
+		return nil, "too many concurrent requests", concurrencyRetryAfter
+	}
+
+	if l.rate > 0 {
+		st.tokens--
+	}
+	st.inflight++
+	l.inflight++
+	start := now
+	return func() { l.release(st, l.now().Sub(start)) }, "", 0
+}
+
+func (l *limiter) release(st *callerState, rtt time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inflight := l.inflight
+	st.inflight--
+	l.inflight--
+	if l.adaptive {
+		l.updateLimit(rtt, inflight)
+	}
+}
+
+// 参考 Netflix concurrency-limits 的 Gradient2：短期延迟高于长期平均延迟时说明请求开始排队，
+// 按两者的比值降低上限；延迟平稳时每次增加约 sqrt(limit) 的排队余量
+func (l *limiter) updateLimit(rtt time.Duration, inflight int) {
+	sample := float64(rtt)
+	if l.longRTT == 0 {
+		l.shortRTT, l.longRTT = sample, sample
+	}
+	l.shortRTT += (sample - l.shortRTT) * 0.1
+	l.longRTT += (sample - l.longRTT) * 0.01
+	// 长期平均延迟远高于当前延迟说明负载已经回落，加快长期平均值的下降
+	if l.longRTT > 2*l.shortRTT {
+		l.longRTT *= 0.95
+	}
+	// 在途请求不到上限的一半时延迟不能说明上限是否合适
+	if float64(inflight) < l.limit/2 {
+		return
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.longRTT/l.shortRTT))
+	next := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = math.Max(l.minLimit, math.Min(l.maxLimit, l.limit*0.8+next*0.2))
+}
+
+// 删除没有在途请求并且令牌桶已满的调用方，避免按 IP 区分时状态无限增长
+func (l *limiter) sweep(now time.Time) {
+	for key, st := range l.callers {
+		if st.inflight == 0 && now.Sub(st.last) >= l.bucketRefill {
+			delete(l.callers, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// 返回 RESOURCE_EXHAUSTED，并通过 retry-after、grpc-retry-pushback-ms 和 RetryInfo 告知重试间隔
+func (l *limiter) reject(ctx context.Context, method, reason string, retryAfter time.Duration) error {
+	secs := max(int(math.Ceil(retryAfter.Seconds())), 1)
+	grpc.SetHeader(ctx, metadata.Pairs(retryAfterKey, strconv.Itoa(secs)))
+	grpc.SetTrailer(ctx, metadata.Pairs(retryPushbackKey, strconv.FormatInt(retryAfter.Milliseconds(), 10)))
+
+	st := status.Newf(codes.ResourceExhausted, "%s: %s, retry after %v", method, reason, retryAfter.Round(time.Millisecond))
+	if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(retryAfter)}); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// 网关的响应头：retry-after 原样作为 HTTP Retry-After，其余 metadata 按网关默认规则加上前缀
+func gatewayOutgoingHeaderMatcher(key string) (string, bool) {
+	if key == retryAfterKey {
+		return "Retry-After", true
+	}
+	return runtime.MetadataHeaderPrefix + key, true
+}
+
+func (l *limiter) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if l.exempt(ctx, info.FullMethod) {
+		return handler(ctx, req)
+	}
+	release, reason, retryAfter := l.acquire(l.callerKey(ctx))
+	if release == nil {
+		return nil, l.reject(ctx, info.FullMethod, reason, retryAfter)
+	}
+	defer release()
+	return handler(ctx, req)
+}
+
+func (l *limiter) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := ss.Context()
+	if l.exempt(ctx, info.FullMethod) {
+		return handler(srv, ss)
+	}
+	release, reason, retryAfter := l.acquire(l.callerKey(ctx))
+	if release == nil {
+		return l.reject(ctx, info.FullMethod, reason, retryAfter)
+	}
+	defer release()
+	return handler(srv, ss)
+}
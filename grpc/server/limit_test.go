@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"test/grpc/auth"
+	ecpb "test/grpc/hello"
+)
+
+// 手动推进的时钟
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(t *testing.T, cfg limitConfig) (*limiter, *fakeClock) {
+	t.Helper()
+	l, err := newLimiter(cfg, "internal-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	l.now = clock.now
+	l.lastSweep = clock.now()
+	return l, clock
+}
+
+// 获取名额，期望成功
+func mustAcquire(t *testing.T, l *limiter, key string) func() {
+	t.Helper()
+	release, reason, _ := l.acquire(key)
+	if release == nil {
+		t.Fatalf("acquire(%q) rejected: %s", key, reason)
+	}
+	return release
+}
+
+// 获取名额，期望以 reason 被拒绝，返回建议的重试间隔
+func mustReject(t *testing.T, l *limiter, key, reason string) time.Duration {
+	t.Helper()
+	release, got, retryAfter := l.acquire(key)
+	if release != nil {
+		release()
+		t.Fatalf("acquire(%q) succeeded, want %q", key, reason)
+	}
+	if got != reason {
+		t.Fatalf("acquire(%q) rejected with %q, want %q", key, got, reason)
+	}
+	return retryAfter
+}
+
+func TestNewLimiterErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  limitConfig
+	}{
+		{"unknown key", limitConfig{KeyBy: "user"}},
+		{"negative rate", limitConfig{Rate: -1}},
+		{"negative burst", limitConfig{Rate: 1, Burst: -1}},
+		{"negative concurrency", limitConfig{MaxConcurrent: -1}},
+		{"adaptive without max", limitConfig{Adaptive: true}},
+		{"adaptive min above max", limitConfig{Adaptive: true, MaxConcurrent: 5, MinConcurrent: 10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newLimiter(tt.cfg, ""); err == nil {
+				t.Error("newLimiter succeeded, want error")
+			}
+		})
+	}
+
+	l, err := newLimiter(limitConfig{}, "")
+	if err != nil || l.enabled() {
+		t.Errorf("zero config: enabled %v, err %v, want disabled", l != nil && l.enabled(), err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, clock := newTestLimiter(t, limitConfig{Rate: 2, Burst: 2})
+
+	// 桶满时允许 burst 个突发请求
+	mustAcquire(t, l, "a")()
+	mustAcquire(t, l, "a")()
+	if retryAfter := mustReject(t, l, "a", "rate limit exceeded"); retryAfter != 500*time.Millisecond {
+		t.Errorf("retry after %v, want 500ms", retryAfter)
+	}
+	// 调用方之间互不影响
+	mustAcquire(t, l, "b")()
+
+	// 每秒补充 2 个令牌
+	clock.advance(250 * time.Millisecond)
+	if retryAfter := mustReject(t, l, "a", "rate limit exceeded"); retryAfter != 250*time.Millisecond {
+		t.Errorf("retry after %v, want 250ms", retryAfter)
+	}
+	clock.advance(250 * time.Millisecond)
+	mustAcquire(t, l, "a")()
+	mustReject(t, l, "a", "rate limit exceeded")
+
+	// 空闲再久令牌也不超过 burst
+	clock.advance(time.Hour)
+	mustAcquire(t, l, "a")()
+	mustAcquire(t, l, "a")()
+	mustReject(t, l, "a", "rate limit exceeded")
+}
+
+func TestDefaultBurst(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{Rate: 2.5})
+	if l.burst != 3 {
+		t.Errorf("burst = %v, want 3", l.burst)
+	}
+	if l.bucketRefill != 1200*time.Millisecond {
+		t.Errorf("bucket refill = %v, want 1.2s", l.bucketRefill)
+	}
+}
+
+func TestConcurrencyPerCaller(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{MaxConcurrentPerCaller: 2})
+
+	release := mustAcquire(t, l, "a")
+	mustAcquire(t, l, "a")
+	if retryAfter := mustReject(t, l, "a", "too many concurrent requests from caller"); retryAfter != concurrencyRetryAfter {
+		t.Errorf("retry after %v, want %v", retryAfter, concurrencyRetryAfter)
+	}
+	mustAcquire(t, l, "b")
+
+	release()
+	mustAcquire(t, l, "a")
+}
+
+func TestConcurrencyPerInstance(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{MaxConcurrent: 2})
+
+	release := mustAcquire(t, l, "a")
+	mustAcquire(t, l, "b")
+	mustReject(t, l, "c", "too many concurrent requests")
+
+	release()
+	mustAcquire(t, l, "c")
+	if l.inflight != 2 {
+		t.Errorf("inflight = %d, want 2", l.inflight)
+	}
+}
+
+// 被拒绝的请求不消耗令牌，也不占用并发名额
+func TestRejectedRequestsConsumeNothing(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{Rate: 1, Burst: 2, MaxConcurrent: 1})
+
+	release := mustAcquire(t, l, "a")
+	mustReject(t, l, "a", "too many concurrent requests")
+	release()
+	mustAcquire(t, l, "a")()
+	mustReject(t, l, "a", "rate limit exceeded")
+	if l.inflight != 0 {
+		t.Errorf("inflight = %d, want 0", l.inflight)
+	}
+}
+
+func TestAdaptiveLimit(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{MaxConcurrent: 100, MinConcurrent: 5, Adaptive: true})
+	sample := func(rtt time.Duration, n int) {
+		for range n {
+			l.updateLimit(rtt, int(l.limit))
+		}
+	}
+
+	// 延迟平稳时保持在上限
+	sample(10*time.Millisecond, 200)
+	if l.limit != 100 {
+		t.Fatalf("limit = %v with steady latency, want 100", l.limit)
+	}
+
+	// 延迟升高说明请求开始排队，上限下降但不低于下限
+	sample(100*time.Millisecond, 30)
+	shrunk := l.limit
+	if shrunk >= 50 {
+		t.Errorf("limit = %v after latency rose tenfold, want below 50", shrunk)
+	}
+	sample(100*time.Millisecond, 30)
+	if l.limit < 5 {
+		t.Errorf("limit = %v, want at least the minimum 5", l.limit)
+	}
+
+	// 延迟恢复后上限逐步回升到最大值
+	sample(10*time.Millisecond, 10)
+	if l.limit <= shrunk && l.limit <= 5 {
+		t.Errorf("limit = %v did not grow after latency recovered", l.limit)
+	}
+	sample(10*time.Millisecond, 500)
+	if l.limit != 100 {
+		t.Errorf("limit = %v after latency recovered, want 100", l.limit)
+	}
+
+	// 在途请求不到上限一半时不调整
+	before := l.limit
+	l.updateLimit(time.Second, 10)
+	if l.limit != before {
+		t.Errorf("limit changed from %v to %v with low concurrency", before, l.limit)
+	}
+}
+
+// 请求耗时用注入的时钟测量
+func TestAdaptiveLimitMeasuresLatency(t *testing.T) {
+	l, clock := newTestLimiter(t, limitConfig{MaxConcurrent: 10, Adaptive: true})
+	release := mustAcquire(t, l, "a")
+	clock.advance(30 * time.Millisecond)
+	release()
+	if l.longRTT != float64(30*time.Millisecond) {
+		t.Errorf("measured %v, want 30ms", time.Duration(l.longRTT))
+	}
+}
+
+func TestSweepIdleCallers(t *testing.T) {
+	l, clock := newTestLimiter(t, limitConfig{Rate: 1, Burst: 1})
+
+	mustAcquire(t, l, "idle")()
+	busy := mustAcquire(t, l, "busy")
+	clock.advance(l.sweepInterval)
+	mustAcquire(t, l, "new")()
+
+	if _, ok := l.callers["idle"]; ok {
+		t.Error("idle caller not swept")
+	}
+	if _, ok := l.callers["busy"]; !ok {
+		t.Error("caller with in-flight requests swept")
+	}
+	busy()
+
+	// 未到清理间隔时不清理
+	clock.advance(l.sweepInterval / 2)
+	mustAcquire(t, l, "other")()
+	if _, ok := l.callers["new"]; !ok {
+		t.Error("caller swept before the sweep interval")
+	}
+}
+
+func TestExempt(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{Rate: 1})
+	withKey := func(key string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(auth.APIKeyKey, key))
+	}
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		want   bool
+	}{
+		{"health check", context.Background(), "/grpc.health.v1.Health/Check", true},
+		{"internal key", withKey("internal-key"), "/hello.HelloService/SayHello", true},
+		{"other key", withKey("alice-key"), "/hello.HelloService/SayHello", false},
+		{"no key", context.Background(), "/hello.HelloService/SayHello", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.exempt(tt.ctx, tt.method); got != tt.want {
+				t.Errorf("exempt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 没有内部 key 时空的 x-api-key 不能豁免
+	l.internalKey = ""
+	if l.exempt(withKey(""), "/hello.HelloService/SayHello") {
+		t.Error("empty API key exempted without an internal key")
+	}
+}
+
+func TestCallerKey(t *testing.T) {
+	remote := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 4000}}
+	loopback := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4000}}
+	ctx := func(p *peer.Peer, md metadata.MD, principal *auth.Principal) context.Context {
+		c := peer.NewContext(context.Background(), p)
+		c = metadata.NewIncomingContext(c, md)
+		if principal != nil {
+			// 通过认证拦截器放入调用方
+			a, _ := auth.NewAuthenticator(auth.Config{APIKeys: map[string]string{"k": principal.Name}})
+			a.UnaryServerInterceptor(metadata.NewIncomingContext(c, metadata.Join(md, metadata.Pairs(auth.APIKeyKey, "k"))), nil,
+				&grpc.UnaryServerInfo{FullMethod: "/hello.HelloService/SayHello"},
+				func(authed context.Context, _ any) (any, error) {
+					c = authed
+					return nil, nil
+				})
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		keyBy string
+		ctx   context.Context
+		want  string
+	}{
+		{"caller", limitKeyCaller, ctx(remote, nil, &auth.Principal{Name: "alice"}), "api-key:alice"},
+		{"caller falls back to IP", limitKeyCaller, ctx(remote, nil, nil), "ip:203.0.113.7"},
+		{"IP", limitKeyIP, ctx(remote, nil, &auth.Principal{Name: "alice"}), "ip:203.0.113.7"},
+		{"API key", limitKeyAPIKey, ctx(remote, metadata.Pairs(auth.APIKeyKey, "secret"), nil), "api-key:secret"},
+		{"API key falls back to IP", limitKeyAPIKey, ctx(remote, nil, nil), "ip:203.0.113.7"},
+		// 网关转发的请求来自回环地址，使用 x-forwarded-for 中最后一跳
+		{"gateway", limitKeyIP, ctx(loopback, metadata.Pairs("x-forwarded-for", "198.51.100.1, 192.0.2.9"), nil), "ip:192.0.2.9"},
+		{"loopback without forwarding", limitKeyIP, ctx(loopback, nil, nil), "ip:127.0.0.1"},
+		// 不是回环地址时不信任客户端自带的 x-forwarded-for
+		{"spoofed forwarding", limitKeyIP, ctx(remote, metadata.Pairs("x-forwarded-for", "192.0.2.9"), nil), "ip:203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newTestLimiter(t, limitConfig{Rate: 1, KeyBy: tt.keyBy})
+			if got := l.callerKey(tt.ctx); got != tt.want {
+				t.Errorf("callerKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type helloServer struct {
+	ecpb.UnimplementedHelloServiceServer
+}
+
+func (helloServer) SayHello(context.Context, *ecpb.HelloRequest) (*ecpb.HelloResponse, error) {
+	return &ecpb.HelloResponse{Message: "hello"}, nil
+}
+
+// 被拒绝的请求返回 RESOURCE_EXHAUSTED，并在 header、trailer 和 RetryInfo 中给出重试间隔
+func TestRejectStatus(t *testing.T) {
+	l, _ := newTestLimiter(t, limitConfig{Rate: 0.5, Burst: 1, KeyBy: limitKeyIP})
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(l.unaryInterceptor))
+	ecpb.RegisterHelloServiceServer(s, helloServer{})
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := ecpb.NewHelloServiceClient(conn)
+
+	if _, err := client.SayHello(context.Background(), &ecpb.HelloRequest{}); err != nil {
+		t.Fatalf("first request rejected: %v", err)
+	}
+
+	var header, trailer metadata.MD
+	_, err = client.SayHello(context.Background(), &ecpb.HelloRequest{}, grpc.Header(&header), grpc.Trailer(&trailer))
+	st := status.Convert(err)
+	if st.Code() != codes.ResourceExhausted {
+		t.Fatalf("got %v, want ResourceExhausted", err)
+	}
+	if got := header.Get(retryAfterKey); len(got) != 1 || got[0] != "2" {
+		t.Errorf("%s = %v, want [2]", retryAfterKey, got)
+	}
+	if got := trailer.Get(retryPushbackKey); len(got) != 1 || got[0] != "2000" {
+		t.Errorf("%s = %v, want [2000]", retryPushbackKey, got)
+	}
+	var retryInfo *errdetails.RetryInfo
+	for _, d := range st.Details() {
+		if ri, ok := d.(*errdetails.RetryInfo); ok {
+			retryInfo = ri
+		}
+	}
+	if retryInfo == nil || retryInfo.RetryDelay.AsDuration() != 2*time.Second {
+		t.Errorf("RetryInfo = %v, want a 2s retry delay", retryInfo)
+	}
+
+}
+
+func TestGatewayOutgoingHeaderMatcher(t *testing.T) {
+	if got, ok := gatewayOutgoingHeaderMatcher(retryAfterKey); got != "Retry-After" || !ok {
+		t.Errorf("retry-after mapped to %q, %v", got, ok)
+	}
+	if got, ok := gatewayOutgoingHeaderMatcher("x-request-id"); got != "Grpc-Metadata-x-request-id" || !ok {
+		t.Errorf("x-request-id mapped to %q, %v", got, ok)
+	}
+}
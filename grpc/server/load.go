@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/orca"
+)
+
+// 以在途请求数占容量的比例作为应用利用率，通过 ORCA 随每个响应的 trailer 上报，
+// 客户端的 least_load 负载均衡器开启 useServerLoad 后据此避开繁忙实例
+type loadReporter struct {
+	capacity int
+	inflight atomic.Int64
+}
+
+func newLoadReporter(capacity int) *loadReporter {
+	return &loadReporter{capacity: max(capacity, 1)}
+}
+
+// ORCA 拦截器需要在外层，负责在处理结束后写入 trailer
+func (l *loadReporter) serverOptions() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		orca.CallMetricsServerOption(nil),
+		grpc.ChainUnaryInterceptor(l.unaryInterceptor),
+	}
+}
+
+func (l *loadReporter) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	n := l.inflight.Add(1)
+	defer l.inflight.Add(-1)
+
+	orca.CallMetricsRecorderFromContext(ctx).SetApplicationUtilization(float64(n) / float64(l.capacity))
+	return handler(ctx, req)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+)
+
+// 单端口模式下的请求分流：content-type 为 application/grpc 的 HTTP/2 请求交给 grpc.Server，
+// 其余请求（REST 网关等）交给 gateway。
+// grpc.Server.ServeHTTP 使用 net/http 的 HTTP/2 实现，性能低于 Serve，也不支持 keepalive 等部分 ServerOption，
+// 对性能敏感时使用双端口模式
+func singlePortHandler(grpcServer *grpc.Server, gateway http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			grpcServer.ServeHTTP(w, r)
+			return
+		}
+		gateway.ServeHTTP(w, r)
+	})
+}
+
+// 同时接受 HTTP/1.1 和明文 HTTP/2（h2c），gRPC 客户端不使用 TLS 时直接以 HTTP/2 连接
+func enableH2C(server *http.Server) {
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+}
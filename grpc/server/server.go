@@ -2,13 +2,33 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"test/grpc/auth"
+	"test/grpc/discovery"
 	"test/grpc/hello"
+	"test/grpc/metrics"
+	"test/grpc/security"
+	"test/grpc/tracing"
+	"time"
 )
 
 type HelloServer struct {
@@ -16,44 +36,280 @@ type HelloServer struct {
 }
 
 func (s *HelloServer) SayHello(ctx context.Context, req *hello.HelloRequest) (*hello.HelloResponse, error) {
+	// 处理逻辑的 span 挂在 gRPC 服务端 span 之下，未开启链路追踪时为空操作
+	_, span := tracing.Tracer().Start(ctx, "HelloServer.SayHello")
+	defer span.End()
+	span.SetAttributes(attribute.String("hello.name", req.Name))
+
 	return &hello.HelloResponse{
 		Message: "Hello, " + req.Name,
 	}, nil
 }
 
 func main() {
-	l, err := net.Listen("tcp", ":8080")
+	cfg, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	s := grpc.NewServer()
+	// 开启链路追踪时，网关的 HTTP 请求、回环 gRPC 调用和服务端处理串成一条链路
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, cfg.ServiceName)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	hello.RegisterHelloServiceServer(s, &HelloServer{})
+	l, err := net.Listen("tcp", cfg.GRPCAddr)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	go func() {
-		if err := s.Serve(l); err != nil {
+	// 通过 ORCA 上报负载，供客户端按负载选择实例
+	opts := newLoadReporter(cfg.LoadCapacity).serverOptions()
+
+	// Prometheus 指标在网关的 /metrics 上提供；RPC 指标的拦截器在认证和限流之前，拒绝的请求也会被统计
+	reg := metrics.NewRegistry()
+	rpcMetrics := metrics.NewServerMetrics(reg)
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(rpcMetrics.UnaryServerInterceptor),
+		grpc.ChainStreamInterceptor(rpcMetrics.StreamServerInterceptor),
+	)
+
+	// 健康检查的回环请求携带随机生成的内部 key，通过认证并且不受限流和故障注入影响
+	internalKey := rand.Text()
+
+	// 通过配置开启故障注入，用于验证客户端的重试和对冲策略
+	faults, err := newFaultInjector(cfg.Fault, internalKey)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// 配置了 JWT 或 API key 时开启认证
+	if cfg.Auth.Enabled() {
+		cfg.Auth.InternalKey = internalKey
+		authenticator, err := auth.NewAuthenticator(cfg.Auth)
+		if err != nil {
 			log.Fatalln(err)
 		}
-	}()
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(authenticator.UnaryServerInterceptor),
+			grpc.ChainStreamInterceptor(authenticator.StreamServerInterceptor),
+		)
+		log.Printf("Authentication enabled with %d API keys", len(cfg.Auth.APIKeys))
+	}
+	// 限流在认证之后，按认证得到的调用方区分
+	limits, err := newLimiter(cfg.Limit, internalKey)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if limits.enabled() {
+		log.Printf("Rate limiting enabled: %v req/s per %s (burst %v), max concurrent %d per caller, %d per instance (adaptive %v)",
+			limits.rate, limits.keyBy, limits.burst, limits.maxPerCaller, int(limits.maxLimit), limits.adaptive)
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(limits.unaryInterceptor),
+			grpc.ChainStreamInterceptor(limits.streamInterceptor),
+		)
+	}
+	if cfg.Tracing.Enabled() {
+		log.Printf("Tracing enabled, exporting spans to %s", cfg.Tracing.Exporter)
+		opts = append(opts, tracing.ServerOption())
+	}
+	if faults.enabled() {
+		log.Printf("Fault injection enabled: error rate %v (%v), delay %v", faults.errorRate, faults.errorCode, faults.delay)
+		opts = append(opts, grpc.ChainUnaryInterceptor(faults.unaryInterceptor))
+	}
+
+	// 配置了证书时启用 TLS，证书文件变化后自动重新加载
+	loopbackCreds := insecure.NewCredentials()
+	var serverTLS *tls.Config
+	if cfg.TLS.Enabled() {
+		// 回环连接使用本实例的证书：按本实例的 SPIFFE ID 校验服务端，没有 SPIFFE ID 时按证书中的主机名校验；
+		// 开启 mTLS 且限制了客户端身份时也要允许本实例自己
+		loopbackTLS := cfg.TLS
+		loopbackTLS.RequireClientCert = false
+		loopbackTLS.AllowedIDs = nil
+		if names, err := security.CertificateNames(cfg.TLS.CertFile); err == nil && len(names) > 0 {
+			loopbackTLS.ServerName = names[0]
+		}
+		if id, err := security.CertificateID(cfg.TLS.CertFile); err == nil {
+			loopbackTLS.AllowedIDs = []string{id}
+			if len(cfg.TLS.AllowedIDs) > 0 {
+				cfg.TLS.AllowedIDs = append(cfg.TLS.AllowedIDs, id)
+			}
+		} else if loopbackTLS.ServerName == "" {
+			log.Fatalf("TLS certificate %s has neither a SPIFFE ID nor a DNS/IP name to verify the loopback connection", cfg.TLS.CertFile)
+		}
+
+		serverReloader, err := security.NewReloader(cfg.TLS)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer serverReloader.Close()
+		loopbackReloader, err := security.NewReloader(loopbackTLS)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer loopbackReloader.Close()
+
+		serverTLS = serverReloader.ServerTLSConfig()
+		loopbackCreds = loopbackReloader.ClientCredentials()
+		// 单端口模式下 TLS 由 HTTP 服务终结
+		if !cfg.SinglePort {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+		}
+		log.Printf("TLS enabled (client certificates required: %v)", cfg.TLS.RequireClientCert)
+	}
+
+	s := grpc.NewServer(opts...)
+
+	hello.RegisterHelloServiceServer(s, &HelloServer{})
+
+	// 注册标准健康服务，检查通过前所有服务均为 NOT_SERVING
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthServer.SetServingStatus(hello.HelloService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(s, healthServer)
+
+	// 任一服务异常退出时也走优雅退出流程
+	serveErr := make(chan error, 2)
+	if !cfg.SinglePort {
+		go func() {
+			if err := s.Serve(l); err != nil {
+				serveErr <- fmt.Errorf("gRPC server: %v", err)
+			}
+		}()
+	}
+
+	// 带租约注册到 etcd，进程退出后租约过期自动下线
+	etcdClient, err := clientv3.New(clientv3.Config{
+		Endpoints:   cfg.EtcdEndpoints,
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer etcdClient.Close()
 
-	conn, err := grpc.NewClient("127.0.0.1:8080", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	registrar, err := discovery.NewRegistrar(etcdClient, cfg.ServiceName, cfg.InstanceID, discovery.ServiceInfo{
+		Addr:     cfg.AdvertiseAddr,
+		Weight:   cfg.Weight,
+		Metadata: cfg.Metadata,
+		Version:  cfg.Version,
+	}, discovery.DefaultTTL, discovery.Options{KeyPrefix: cfg.KeyPrefix})
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	gwmux := runtime.NewServeMux()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err = registrar.Register(ctx)
+	cancel()
+	if err != nil {
+		log.Fatalln(err)
+	}
 
-	if err := hello.RegisterHelloServiceHandler(context.Background(), gwmux, conn); err != nil {
+	// 健康检查和网关都通过回环连接访问本实例
+	dialOpts := []grpc.DialOption{grpc.WithTransportCredentials(loopbackCreds)}
+	if cfg.Tracing.Enabled() {
+		dialOpts = append(dialOpts, tracing.DialOption())
+	}
+	conn, err := grpc.NewClient(hostAddr(l.Addr().String(), "127.0.0.1"), dialOpts...)
+	if err != nil {
 		log.Fatalln(err)
 	}
+	defer conn.Close()
 
-	server := http.Server{
-		Addr:    ":8081",
-		Handler: gwmux,
+	// 网关把 REST 请求的 Authorization 和 X-Api-Key 头转发为 gRPC metadata，
+	// 限流返回的 retry-after 转换为 HTTP Retry-After 头（RESOURCE_EXHAUSTED 对应 429）
+	gwOpts := []runtime.ServeMuxOption{
+		runtime.WithIncomingHeaderMatcher(auth.GatewayHeaderMatcher),
+		runtime.WithOutgoingHeaderMatcher(gatewayOutgoingHeaderMatcher),
+		runtime.WithMiddlewares(metrics.NewGatewayMetrics(reg).Middleware),
+	}
+	if cfg.Tracing.Enabled() {
+		gwOpts = append(gwOpts, runtime.WithMiddlewares(tracing.GatewayMiddleware))
 	}
+	gwmux := runtime.NewServeMux(gwOpts...)
 
-	if err := server.ListenAndServe(); err != nil {
+	if err := hello.RegisterHelloServiceHandler(context.Background(), gwmux, conn); err != nil {
 		log.Fatalln(err)
 	}
+
+	httpMux := http.NewServeMux()
+	httpMux.Handle("/metrics", metrics.Handler(reg))
+	httpMux.Handle("/", gwmux)
+	var httpHandler http.Handler = httpMux
+	if cfg.Tracing.Enabled() {
+		httpHandler = tracing.HTTPHandler(httpMux, "/metrics")
+	}
+
+	server := &http.Server{
+		Addr:      cfg.GatewayAddr,
+		Handler:   httpHandler,
+		TLSConfig: serverTLS,
+	}
+
+	// 单端口模式下网关和 gRPC 共用 gRPC 监听地址，否则网关单独监听
+	go func() {
+		var err error
+		switch {
+		case cfg.SinglePort && serverTLS != nil:
+			server.Handler = singlePortHandler(s, httpHandler)
+			err = server.ServeTLS(l, "", "")
+		case cfg.SinglePort:
+			server.Handler = singlePortHandler(s, httpHandler)
+			enableH2C(server)
+			err = server.Serve(l)
+		case serverTLS != nil:
+			err = server.ListenAndServeTLS("", "")
+		default:
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serveErr <- fmt.Errorf("HTTP gateway: %v", err)
+		}
+	}()
+
+	if cfg.SinglePort {
+		log.Printf("Registered %s as %s (gRPC and gateway %s)", cfg.AdvertiseAddr, cfg.InstanceID, cfg.GRPCAddr)
+	} else {
+		log.Printf("Registered %s as %s (gRPC %s, gateway %s)", cfg.AdvertiseAddr, cfg.InstanceID, cfg.GRPCAddr, cfg.GatewayAddr)
+	}
+
+	// 定期通过回环连接调用 SayHello 检查服务是否可用
+	checker := newHealthChecker(healthServer, cfg.HealthCheck.Interval, cfg.HealthCheck.Timeout)
+	helloClient := hello.NewHelloServiceClient(conn)
+	checker.register(hello.HelloService_ServiceDesc.ServiceName, func(ctx context.Context) error {
+		// 健康检查每隔几秒执行一次，不记录链路
+		ctx = tracing.WithoutSampling(ctx)
+		ctx = metadata.AppendToOutgoingContext(ctx, auth.APIKeyKey, internalKey)
+		_, err := helloClient.SayHello(ctx, &hello.HelloRequest{Name: "health-check"})
+		return err
+	})
+	checker.start()
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case <-sigCtx.Done():
+		log.Printf("Received shutdown signal")
+	case err := <-serveErr:
+		log.Printf("%v, shutting down", err)
+	}
+	// 恢复默认信号处理，退出过程中再次收到信号时直接终止进程
+	stop()
+
+	(&shutdownSequence{
+		registrar:    registrar,
+		checker:      checker,
+		healthServer: healthServer,
+		httpServer:   server,
+		grpcServer:   s,
+		flushTraces:  shutdownTracing,
+		drainDelay:   cfg.Shutdown.DrainDelay,
+		singlePort:   cfg.SinglePort,
+		timeout:      cfg.Shutdown.Timeout,
+	}).run()
 }
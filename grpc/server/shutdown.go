@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"test/grpc/discovery"
+)
+
+// 收到退出信号后依次执行的优雅退出流程
+type shutdownSequence struct {
+	registrar    *discovery.Registrar
+	checker      *healthChecker
+	healthServer *health.Server
+	httpServer   *http.Server
+	grpcServer   *grpc.Server
+	// gRPC 请求是否由 httpServer 承载（单端口模式）
+	singlePort bool
+	// 导出缓冲中的 span，未开启链路追踪时为空操作
+	flushTraces func(context.Context) error
+
+	// 摘除流量后等待客户端感知的时间
+	drainDelay time.Duration
+	// 排空在途请求的总时限，超时后强制停止
+	timeout time.Duration
+}
+
+// run 按以下顺序退出：
+//
+//  1. 从 etcd 注销，客户端的 resolver 不再返回本实例
+//  2. 健康状态改为 NOT_SERVING，已建立连接的客户端通过健康检查摘除本实例
+//  3. 等待 drainDelay，让客户端完成摘除
+//  4. 先关闭 HTTP 网关，再 GracefulStop gRPC：网关的请求经回环连接转发到 gRPC，
+//     必须在 gRPC 停止接收新请求前处理完；超过 timeout 仍未排空则强制停止。
+//     单端口模式下 gRPC 请求也由 HTTP 服务承载，关闭 HTTP 服务时一并排空，之后直接 Stop：
+//     ServeHTTP 建立的连接不支持 GracefulStop 所需的 Drain，调用会 panic
+//  5. 导出剩余的 span
+func (s *shutdownSequence) run() {
+	start := time.Now()
+
+	if err := s.registrar.Close(); err != nil {
+		log.Printf("Failed to deregister from etcd: %v", err)
+	} else {
+		log.Printf("Deregistered from etcd")
+	}
+
+	s.checker.close()
+	s.healthServer.Shutdown()
+	log.Printf("Health status set to NOT_SERVING, waiting %v for clients to drain", s.drainDelay)
+	time.Sleep(s.drainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		log.Printf("HTTP gateway did not shut down gracefully: %v", err)
+		s.httpServer.Close()
+	}
+
+	if s.singlePort {
+		s.grpcServer.Stop()
+	} else {
+		s.drainGRPC(ctx)
+	}
+
+	// 排空用的 ctx 可能已经超时，导出 span 单独计时
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer flushCancel()
+	if err := s.flushTraces(flushCtx); err != nil {
+		log.Printf("Failed to flush traces: %v", err)
+	}
+
+	log.Printf("Shutdown completed in %v", time.Since(start).Round(time.Millisecond))
+}
+
+// drainGRPC 等待在途 RPC 完成，ctx 超时后强制停止
+func (s *shutdownSequence) drainGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("gRPC server did not drain within %v, forcing stop", s.timeout)
+		s.grpcServer.Stop()
+		<-stopped
+	}
+}
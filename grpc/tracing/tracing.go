@@ -0,0 +1,165 @@
+// Package tracing 配置 OpenTelemetry 链路追踪：导出到 OTLP 或标准输出，
+// 通过 W3C traceparent 在 HTTP 网关、gRPC 客户端和服务端之间传播上下文
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+)
+
+// 导出方式
+const (
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// 链路追踪配置，Exporter 为空时不开启
+type Config struct {
+	// stdout 或 otlp
+	Exporter string `yaml:"exporter"`
+	// OTLP gRPC 接收端地址，为空时使用 OTEL_EXPORTER_OTLP_ENDPOINT 或 localhost:4317
+	Endpoint string `yaml:"endpoint"`
+	// 不使用 TLS 连接 OTLP 接收端，本地的 collector 或 Jaeger 通常需要开启
+	Insecure bool `yaml:"insecure"`
+	// 根 span 的采样比例，默认 1；有上游 span 时跟随上游的采样决定
+	SampleRatio float64 `yaml:"sampleRatio"`
+}
+
+// Enabled 配置了导出方式时开启
+func (c *Config) Enabled() bool {
+	return c.Exporter != ""
+}
+
+// AddFlags 注册客户端使用的链路追踪命令行参数
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Exporter, "trace-exporter", c.Exporter, "trace exporter: stdout or otlp, empty disables tracing")
+	fs.StringVar(&c.Endpoint, "otlp-endpoint", c.Endpoint, "OTLP gRPC endpoint (default localhost:4317)")
+	fs.BoolVar(&c.Insecure, "otlp-insecure", c.Insecure, "connect to the OTLP endpoint without TLS")
+	fs.Float64Var(&c.SampleRatio, "trace-sample-ratio", c.SampleRatio, "fraction of root spans to sample (default 1)")
+}
+
+// Setup 创建导出器并设置全局的 TracerProvider 和 W3C 传播器；
+// 返回的 shutdown 在退出前调用，把缓冲的 span 全部导出。未开启时 shutdown 为空操作
+func Setup(ctx context.Context, cfg Config, serviceName string) (shutdown func(context.Context) error, err error) {
+	if !cfg.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid trace sample ratio %v", cfg.SampleRatio)
+	}
+	ratio := cfg.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, must be stdout or otlp", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %v", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// ServerOption gRPC 服务端为每个 RPC 创建 span，父 span 来自请求 metadata 中的 traceparent
+func ServerOption() grpc.ServerOption {
+	return grpc.StatsHandler(otelgrpc.NewServerHandler())
+}
+
+// DialOption gRPC 客户端为每个 RPC 创建 span，并把 traceparent 写入请求 metadata
+func DialOption() grpc.DialOption {
+	return grpc.WithStatsHandler(otelgrpc.NewClientHandler())
+}
+
+// HTTPHandler 为网关的每个 HTTP 请求创建 span，父 span 来自请求头中的 traceparent。
+// 网关通过带 DialOption 的回环连接转发时，gRPC 客户端把当前 span 写入 metadata 的 traceparent，
+// 从而把 HTTP 请求、gRPC 调用和服务端处理串成一条链路；skip 中的路径（如 /metrics）不记录
+func HTTPHandler(h http.Handler, skip ...string) http.Handler {
+	return otelhttp.NewHandler(h, "gateway",
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			for _, path := range skip {
+				if r.URL.Path == path {
+					return false
+				}
+			}
+			return true
+		}),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
+
+// GatewayMiddleware 通过 runtime.WithMiddlewares 使用，把 HTTP span 的名称改为路由模板（如 POST /v1/hello），
+// 避免路径参数使 span 名称过多
+func GatewayMiddleware(next runtime.HandlerFunc) runtime.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, pathParams map[string]string) {
+		if pattern, ok := runtime.HTTPPattern(r.Context()); ok {
+			span := trace.SpanFromContext(r.Context())
+			span.SetName(r.Method + " " + pattern.String())
+			span.SetAttributes(attribute.String("http.route", pattern.String()))
+		}
+		next(w, r, pathParams)
+	}
+}
+
+// WithoutSampling 返回带有未采样父 span 的 context，其中发起的调用及其下游都不记录 span，
+// 用于健康检查等周期性的内部调用
+func WithoutSampling(ctx context.Context) context.Context {
+	var cfg trace.SpanContextConfig
+	if _, err := rand.Read(cfg.TraceID[:]); err != nil {
+		return ctx
+	}
+	if _, err := rand.Read(cfg.SpanID[:]); err != nil {
+		return ctx
+	}
+	return trace.ContextWithSpanContext(ctx, trace.NewSpanContext(cfg))
+}
+
+// Tracer 返回本项目使用的 Tracer，未开启时为空操作
+func Tracer() trace.Tracer {
+	return otel.Tracer("test/grpc")
+}